@@ -0,0 +1,23 @@
+//go:build !unix
+
+package main
+
+import (
+	"os"
+)
+
+// Check that the process can read a file by opening it
+func checkReadable(filename string) error {
+	f, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+
+	return f.Close()
+}
+
+// Checking that a directory is writable without writing to it is only
+// implemented on Unix
+func checkWritable(dir string) error {
+	return nil
+}
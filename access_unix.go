@@ -0,0 +1,18 @@
+//go:build unix
+
+package main
+
+import (
+	"golang.org/x/sys/unix"
+)
+
+// Check that the process can read a file, without opening it
+func checkReadable(filename string) error {
+	return unix.Access(filename, unix.R_OK)
+}
+
+// Check that the process can create files in a directory, without writing
+// anything
+func checkWritable(dir string) error {
+	return unix.Access(dir, unix.W_OK)
+}
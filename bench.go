@@ -0,0 +1,137 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"runtime/pprof"
+	"time"
+
+	"github.com/peterbourgon/ff/v4"
+)
+
+var (
+	benchIterations *int
+)
+
+// Create the bench subcommand
+func newBenchCommand(parent *ff.FlagSet) *ff.Command {
+	fs := ff.NewFlagSet("bench").SetParent(parent)
+	benchIterations = fs.IntLong(
+		"iterations",
+		10,
+		"Number of times to run the parse/write pipeline",
+	)
+
+	return &ff.Command{
+		Name:      "bench",
+		Usage:     binName + " bench [FLAGS] <OUI_CSV_FILE>",
+		ShortHelp: "Benchmark the parse/write pipeline against a local OUI CSV file",
+		Flags:     fs,
+		Exec:      bench,
+	}
+}
+
+// Repeatedly parse a local OUI CSV file and report throughput
+func bench(ctx context.Context, args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("expected exactly one OUI CSV file, got %d arguments", len(args))
+	}
+
+	if *benchIterations < 1 {
+		return fmt.Errorf("iterations must be at least 1, got %d", *benchIterations)
+	}
+
+	filename := args[0]
+
+	info, err := os.Stat(filename)
+	if err != nil {
+		return fmt.Errorf("error reading OUI CSV file: %w", err)
+	}
+
+	dir, err := os.MkdirTemp("", binName)
+	if err != nil {
+		return fmt.Errorf("error creating temporary directory: %w", err)
+	}
+	defer os.RemoveAll(dir)
+
+	output := filepath.Join(dir, "oui.prom")
+
+	entries := 0
+	start := time.Now()
+
+	for i := 0; i < *benchIterations; i++ {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		// Only parse and render the OUI metrics, without the side effects of
+		// a refresh such as exports, pushes and state files
+		records, err := parseDownload(download{filename: filename})
+		if err != nil {
+			return err
+		}
+
+		samples := ouiSamples(filterOrganizations(records))
+		if err := writeMetrics(output, samples); err != nil {
+			return err
+		}
+
+		entries = len(samples)
+	}
+
+	elapsed := time.Since(start)
+	perIteration := elapsed / time.Duration(*benchIterations)
+
+	fmt.Printf("iterations:      %d\n", *benchIterations)
+	fmt.Printf("input size:      %d bytes\n", info.Size())
+	fmt.Printf("entries:         %d\n", entries)
+	fmt.Printf("total time:      %s\n", elapsed)
+	fmt.Printf("time/iteration:  %s\n", perIteration)
+	fmt.Printf(
+		"throughput:      %.2f MB/s, %.0f entries/s\n",
+		float64(info.Size())/perIteration.Seconds()/1e6,
+		float64(entries)/perIteration.Seconds(),
+	)
+
+	return nil
+}
+
+// Start writing a CPU profile to a file, returning a function which stops it
+func startCPUProfile(filename string) (func(), error) {
+	f, err := os.Create(filename)
+	if err != nil {
+		return nil, fmt.Errorf("error creating CPU profile file: %w", err)
+	}
+
+	if err := pprof.StartCPUProfile(f); err != nil {
+		f.Close()
+
+		return nil, fmt.Errorf("error starting CPU profile: %w", err)
+	}
+
+	return func() {
+		pprof.StopCPUProfile()
+		f.Close()
+	}, nil
+}
+
+// Write a heap profile to a file
+func writeMemProfile(filename string) error {
+	f, err := os.Create(filename)
+	if err != nil {
+		return fmt.Errorf("error creating memory profile file: %w", err)
+	}
+	defer f.Close()
+
+	// Get up-to-date statistics
+	runtime.GC()
+
+	if err := pprof.WriteHeapProfile(f); err != nil {
+		return fmt.Errorf("error writing memory profile: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"log/slog"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Unquote a YAML scalar value
+func unquoteYAML(value string) string {
+	value = strings.TrimSpace(value)
+
+	if len(value) >= 2 && value[0] == '\'' && value[len(value)-1] == '\'' {
+		return strings.ReplaceAll(value[1:len(value)-1], "''", "'")
+	}
+
+	if len(value) >= 2 && value[0] == '"' && value[len(value)-1] == '"' {
+		if unquoted, err := strconv.Unquote(value); err == nil {
+			return unquoted
+		}
+
+		return value[1 : len(value)-1]
+	}
+
+	return value
+}
+
+// Parse the Bluetooth SIG company identifiers YAML file, which lists each
+// company as a value and name pair
+func parseBluetoothCompanies(filename string, logger *slog.Logger) ([]sample, error) {
+	input, err := os.Open(filename)
+	if err != nil {
+		return nil, fmt.Errorf("error opening Bluetooth company identifiers file: %w", err)
+	}
+	defer input.Close()
+
+	samples := []sample{}
+	id := ""
+
+	scanner := bufio.NewScanner(input)
+
+	for scanner.Scan() {
+		line := strings.TrimPrefix(strings.TrimSpace(scanner.Text()), "- ")
+
+		key, value, found := strings.Cut(line, ":")
+		if !found {
+			continue
+		}
+
+		switch strings.TrimSpace(key) {
+		case "value":
+			n, err := strconv.ParseUint(unquoteYAML(value), 0, 16)
+			if err != nil {
+				logger.Error("Bluetooth company identifier has wrong format", "company_id", strings.TrimSpace(value))
+
+				id = ""
+
+				continue
+			}
+
+			id = fmt.Sprintf("0x%04x", n)
+		case "name":
+			if id == "" {
+				continue
+			}
+
+			samples = append(samples, sample{
+				name: *bluetoothMetricName,
+				labels: []label{
+					{name: "company_id", value: id},
+					{name: "company_name", value: unquoteYAML(value)},
+				},
+				value: 1,
+			})
+
+			id = ""
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, withClass("parse", fmt.Errorf("error reading Bluetooth company identifiers file: %w", err))
+	}
+
+	return samples, nil
+}
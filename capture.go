@@ -0,0 +1,99 @@
+package main
+
+import (
+	"log/slog"
+	"net"
+	"sync"
+	"time"
+)
+
+// Set of source MAC addresses observed by passive capture
+type seenSet struct {
+	mu   sync.Mutex
+	seen map[string]time.Time
+}
+
+var capturedMACs = &seenSet{
+	seen: map[string]time.Time{},
+}
+
+// Record that a MAC address has been seen
+func (s *seenSet) observe(mac net.HardwareAddr) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.seen[string(mac)] = time.Now()
+}
+
+// Forget MAC addresses not seen within the TTL and return the remaining ones
+func (s *seenSet) expire(ttl time.Duration) []net.HardwareAddr {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	macs := make([]net.HardwareAddr, 0, len(s.seen))
+
+	for mac, lastSeen := range s.seen {
+		if time.Since(lastSeen) > ttl {
+			delete(s.seen, mac)
+
+			continue
+		}
+
+		macs = append(macs, net.HardwareAddr(mac))
+	}
+
+	return macs
+}
+
+// Start passively capturing source MAC addresses on an interface
+func startCapture(iface string) error {
+	fd, err := openCapture(iface)
+	if err != nil {
+		return err
+	}
+
+	slog.Info("Capturing source MAC addresses", "interface", iface)
+
+	go func() {
+		if err := readCapture(fd, capturedMACs.observe); err != nil {
+			slog.Error("Error capturing packets", "interface", iface, "error", err.Error())
+		}
+	}()
+
+	return nil
+}
+
+// Build samples counting the captured devices of each vendor
+func captureSamples(ouiMap map[string]string) []sample {
+	macs := capturedMACs.expire(*captureTTL)
+	devices := map[string]int{}
+
+	for _, mac := range macs {
+		devices[ouiMap[macOUI(mac)]]++
+	}
+
+	samples := []sample{}
+
+	for organization, count := range devices {
+		samples = append(samples, sample{
+			name:   *captureMetricName,
+			labels: []label{{name: "organization_name", value: organization}},
+			value:  float64(count),
+		})
+	}
+
+	groups := map[string][]net.HardwareAddr{*captureInterface: macs}
+
+	return append(samples, randomizationSamples("capture", "interface", groups)...)
+}
+
+// Update the capture metric file, if enabled and the OUI database is loaded
+func reportCapture(ouiMap map[string]string) {
+	if *captureFile == "" || *captureInterface == "" || ouiMap == nil {
+		return
+	}
+
+	if err := writeMetrics(*captureFile, captureSamples(ouiMap)); err != nil {
+		slog.Error("Error writing capture metric file", "error", err.Error())
+	}
+}
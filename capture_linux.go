@@ -0,0 +1,78 @@
+//go:build linux
+
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"syscall"
+)
+
+// Convert a short from host to network byte order
+func htons(v uint16) uint16 {
+	b := make([]byte, 2)
+	binary.BigEndian.PutUint16(b, v)
+
+	return binary.NativeEndian.Uint16(b)
+}
+
+// Open a raw AF_PACKET socket receiving every frame on an interface
+func openCapture(name string) (int, error) {
+	iface, err := net.InterfaceByName(name)
+	if err != nil {
+		return 0, fmt.Errorf("error finding capture interface: %w", err)
+	}
+
+	protocol := htons(syscall.ETH_P_ALL)
+
+	fd, err := syscall.Socket(syscall.AF_PACKET, syscall.SOCK_RAW, int(protocol))
+	if err != nil {
+		return 0, fmt.Errorf("error opening packet socket: %w", err)
+	}
+
+	err = syscall.Bind(fd, &syscall.SockaddrLinklayer{
+		Protocol: protocol,
+		Ifindex:  iface.Index,
+	})
+	if err != nil {
+		syscall.Close(fd)
+
+		return 0, fmt.Errorf("error binding packet socket: %w", err)
+	}
+
+	return fd, nil
+}
+
+// Read frames from a packet socket, passing each Ethernet source address to
+// observe
+func readCapture(fd int, observe func(net.HardwareAddr)) error {
+	defer syscall.Close(fd)
+
+	// Only the Ethernet header is needed, the rest of the frame is truncated
+	buf := make([]byte, 14)
+
+	for {
+		n, _, err := syscall.Recvfrom(fd, buf, syscall.MSG_TRUNC)
+		if err == syscall.EINTR {
+			continue
+		}
+
+		if err != nil {
+			return fmt.Errorf("error reading packet socket: %w", err)
+		}
+
+		if n < len(buf) {
+			continue
+		}
+
+		src := net.HardwareAddr(buf[6:12])
+
+		// Skip multicast, broadcast and unset sources, which aren't devices
+		if src[0]&0x01 != 0 || src.String() == "00:00:00:00:00:00" {
+			continue
+		}
+
+		observe(append(net.HardwareAddr(nil), src...))
+	}
+}
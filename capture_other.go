@@ -0,0 +1,20 @@
+//go:build !linux
+
+package main
+
+import (
+	"errors"
+	"net"
+)
+
+var errCaptureUnsupported = errors.New("packet capture is not supported on this platform")
+
+// Packet capture is only implemented on Linux
+func openCapture(name string) (int, error) {
+	return 0, errCaptureUnsupported
+}
+
+// Packet capture is only implemented on Linux
+func readCapture(fd int, observe func(net.HardwareAddr)) error {
+	return errCaptureUnsupported
+}
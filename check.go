@@ -0,0 +1,141 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/peterbourgon/ff/v4"
+)
+
+// Nagios plugin exit codes
+const (
+	checkOK       = 0
+	checkWarning  = 1
+	checkCritical = 2
+	checkUnknown  = 3
+)
+
+var (
+	checkWarningAge      *time.Duration
+	checkCriticalAge     *time.Duration
+	checkWarningEntries  *int
+	checkCriticalEntries *int
+
+	checkStateNames = map[int]string{
+		checkOK:       "OK",
+		checkWarning:  "WARNING",
+		checkCritical: "CRITICAL",
+		checkUnknown:  "UNKNOWN",
+	}
+)
+
+// Create the check subcommand
+func newCheckCommand(parent *ff.FlagSet) *ff.Command {
+	fs := ff.NewFlagSet("check").SetParent(parent)
+	checkWarningAge = fs.DurationLong(
+		"warning-age",
+		336*time.Hour,
+		"Age of the metric file after which the check is WARNING",
+	)
+	checkCriticalAge = fs.DurationLong(
+		"critical-age",
+		672*time.Hour,
+		"Age of the metric file after which the check is CRITICAL",
+	)
+	checkWarningEntries = fs.IntLong(
+		"warning-entries",
+		0,
+		"Number of OUIs below which the check is WARNING",
+	)
+	checkCriticalEntries = fs.IntLong(
+		"critical-entries",
+		1,
+		"Number of OUIs below which the check is CRITICAL",
+	)
+
+	return &ff.Command{
+		Name:      "check",
+		Usage:     binName + " check [FLAGS]",
+		ShortHelp: "Check the freshness of the metric file, exiting with Nagios plugin codes",
+		Flags:     fs,
+		Exec:      check,
+	}
+}
+
+// Count the OUIs in the output file, in the format of --output-format.
+// Duplicate records of an OUI are counted once.
+func countEntries(filename string) (int, error) {
+	db, err := readOutputDB(filename)
+	if err != nil {
+		return 0, fmt.Errorf("error reading OUI output file: %w", err)
+	}
+
+	return db.Len(), nil
+}
+
+// Evaluate the metric file against the check thresholds and exit with the
+// matching Nagios plugin code
+func check(ctx context.Context, args []string) error {
+	if len(args) > 0 {
+		return fmt.Errorf("unexpected arguments: %s", strings.Join(args, " "))
+	}
+
+	info, err := os.Stat(*metricFile)
+	if err != nil {
+		fmt.Printf("OUI %s - %s\n", checkStateNames[checkUnknown], err)
+		os.Exit(checkUnknown)
+	}
+
+	entries, err := countEntries(*metricFile)
+	if err != nil {
+		fmt.Printf("OUI %s - %s\n", checkStateNames[checkUnknown], err)
+		os.Exit(checkUnknown)
+	}
+
+	age := time.Since(info.ModTime()).Truncate(time.Second)
+
+	state := checkOK
+	problems := []string{}
+
+	switch {
+	case age >= *checkCriticalAge:
+		state = max(state, checkCritical)
+		problems = append(problems, fmt.Sprintf("metric file older than %s", *checkCriticalAge))
+	case age >= *checkWarningAge:
+		state = max(state, checkWarning)
+		problems = append(problems, fmt.Sprintf("metric file older than %s", *checkWarningAge))
+	}
+
+	switch {
+	case entries < *checkCriticalEntries:
+		state = max(state, checkCritical)
+		problems = append(problems, fmt.Sprintf("fewer than %d OUIs", *checkCriticalEntries))
+	case entries < *checkWarningEntries:
+		state = max(state, checkWarning)
+		problems = append(problems, fmt.Sprintf("fewer than %d OUIs", *checkWarningEntries))
+	}
+
+	summary := fmt.Sprintf("%d OUIs, updated %s ago", entries, age)
+	if len(problems) > 0 {
+		summary += " (" + strings.Join(problems, ", ") + ")"
+	}
+
+	fmt.Printf(
+		"OUI %s - %s | entries=%d;%d:;%d:;0 age=%ds;%d;%d;0\n",
+		checkStateNames[state],
+		summary,
+		entries,
+		*checkWarningEntries,
+		*checkCriticalEntries,
+		int64(age.Seconds()),
+		int64(checkWarningAge.Seconds()),
+		int64(checkCriticalAge.Seconds()),
+	)
+
+	os.Exit(state)
+
+	return nil
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+)
+
+const checkmkService = "OUI database"
+
+// Write a CheckMK local check spool file summarizing the state of the OUI
+// database
+func writeCheckmk(filename string, entries int, lastSuccess time.Time, updateErr error) error {
+	state := 0
+	summary := fmt.Sprintf(
+		"%d OUIs, last updated %s",
+		entries,
+		lastSuccess.Format(time.RFC3339),
+	)
+
+	switch {
+	case lastSuccess.IsZero():
+		state = 2
+		summary = fmt.Sprintf("OUI database has never been updated: %s", updateErr)
+	case updateErr != nil:
+		state = 1
+		summary += fmt.Sprintf(", latest refresh failed: %s", updateErr)
+	}
+
+	// CheckMK treats everything after the metrics field as the summary, which
+	// must fit on a single line
+	summary = strings.ReplaceAll(summary, "\n", " ")
+
+	content := "<<<local:sep(0)>>>\n" + fmt.Sprintf(
+		"%d \"%s\" entries=%d %s\n",
+		state,
+		checkmkService,
+		entries,
+		summary,
+	)
+
+	return writeFileAtomic(filename, func(w *bufio.Writer) error {
+		_, err := w.WriteString(content)
+
+		return err
+	})
+}
+
+// Update the CheckMK local check, if enabled
+func reportCheckmk(entries int, lastSuccess time.Time, updateErr error) {
+	if *checkmkFile == "" {
+		return
+	}
+
+	if err := writeCheckmk(*checkmkFile, entries, lastSuccess, updateErr); err != nil {
+		slog.Error("Error writing CheckMK file", "error", err.Error())
+	}
+}
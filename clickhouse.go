@@ -0,0 +1,95 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	neturl "net/url"
+	"strings"
+)
+
+// Run a query over the ClickHouse HTTP interface, with body as its data
+func clickhouseQuery(ctx context.Context, query string, body io.Reader) error {
+	u, err := neturl.Parse(*clickhouseURL)
+	if err != nil {
+		return fmt.Errorf("error parsing ClickHouse URL: %w", err)
+	}
+
+	params := u.Query()
+	params.Set("query", query)
+	u.RawQuery = params.Encode()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, u.String(), body)
+	if err != nil {
+		return fmt.Errorf("error creating http request: %w", err)
+	}
+
+	req.Header.Set("User-Agent", userAgent)
+
+	if *clickhouseUsername != "" {
+		req.Header.Set("X-ClickHouse-User", *clickhouseUsername)
+		req.Header.Set("X-ClickHouse-Key", *clickhousePassword)
+	}
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("error doing http request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		// ClickHouse explains the error in the response body
+		message, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+
+		return fmt.Errorf("unexpected http status: %s: %s", resp.Status, strings.TrimSpace(string(message)))
+	}
+
+	return nil
+}
+
+// Replace the contents of the ClickHouse table with the OUI database. The
+// rows are inserted into a staging table which is then exchanged with the
+// table, so queries never see a partially loaded table.
+func writeClickHouse(ctx context.Context, records []ouiRecord) error {
+	table := *clickhouseTable
+	staging := table + "_staging"
+
+	var body bytes.Buffer
+
+	enc := json.NewEncoder(&body)
+
+	for _, entry := range ouiEntries(records) {
+		if err := enc.Encode(entry); err != nil {
+			return fmt.Errorf("error encoding ClickHouse rows: %w", err)
+		}
+	}
+
+	// The registry and the prefix are only told apart when registries other
+	// than MA-L are downloaded, so that tables for MA-L alone don't need the
+	// columns. Rows of MA-L assignments leave the prefix to its default.
+	columns := "oui, assignment, organization_name"
+	if len(extraRegistries()) > 0 {
+		columns = "oui, prefix, registry, assignment, organization_name"
+	}
+
+	queries := []struct {
+		query string
+		body  io.Reader
+	}{
+		{fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s AS %s", staging, table), nil},
+		{fmt.Sprintf("TRUNCATE TABLE %s", staging), nil},
+		{fmt.Sprintf("INSERT INTO %s (%s) FORMAT JSONEachRow", staging, columns), &body},
+		{fmt.Sprintf("EXCHANGE TABLES %s AND %s", staging, table), nil},
+	}
+
+	for _, q := range queries {
+		if err := clickhouseQuery(ctx, q.query, q.body); err != nil {
+			return fmt.Errorf("error running ClickHouse query %q: %w", strings.Fields(q.query)[0], err)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"strings"
+
+	"github.com/peterbourgon/ff/v4"
+)
+
+// A flag as it appears in generated documentation and completion scripts
+type flagInfo struct {
+	name        string
+	usage       string
+	placeholder string
+	def         string
+	value       bool
+}
+
+// A command as it appears in generated documentation and completion scripts,
+// with only the flags defined by the command itself
+type commandInfo struct {
+	name  string
+	usage string
+	help  string
+	flags []flagInfo
+	args  []string
+}
+
+// Collect the root command followed by its subcommands from the command
+// definitions
+func describeCommands(root *ff.Command) []commandInfo {
+	commands := []commandInfo{describeCommand(root)}
+	for _, cmd := range root.Subcommands {
+		commands = append(commands, describeCommand(cmd))
+	}
+
+	return commands
+}
+
+func describeCommand(cmd *ff.Command) commandInfo {
+	c := commandInfo{name: cmd.Name, usage: cmd.Usage, help: cmd.ShortHelp}
+	if cmd.Name == "completion" {
+		c.args = completionShells
+	}
+
+	if cmd.Flags == nil {
+		return c
+	}
+
+	_ = cmd.Flags.WalkFlags(func(f ff.Flag) error {
+		name, ok := f.GetLongName()
+		// Parent flags are listed with the command that defines them
+		if !ok || f.GetFlags().GetName() != cmd.Flags.GetName() {
+			return nil
+		}
+
+		c.flags = append(c.flags, flagInfo{
+			name:        name,
+			usage:       f.GetUsage(),
+			placeholder: f.GetPlaceholder(),
+			def:         f.GetDefault(),
+			value:       f.GetPlaceholder() != "",
+		})
+
+		return nil
+	})
+
+	return c
+}
+
+// Name of the environment variable which sets a flag
+func envVarName(flag string) string {
+	return strings.ToUpper(binName + "_" + strings.NewReplacer("-", "_", ".", "_", "/", "_").Replace(flag))
+}
@@ -0,0 +1,214 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/peterbourgon/ff/v4"
+)
+
+// Shells which completion scripts can be generated for
+var completionShells = []string{"bash", "zsh", "fish"}
+
+// Create the completion subcommand
+func newCompletionCommand(parent *ff.FlagSet) *ff.Command {
+	fs := ff.NewFlagSet("completion").SetParent(parent)
+
+	return &ff.Command{
+		Name:      "completion",
+		Usage:     binName + " completion <" + strings.Join(completionShells, "|") + ">",
+		ShortHelp: "Print a shell completion script",
+		Flags:     fs,
+		Exec:      completion,
+	}
+}
+
+// Print the completion script for the requested shell
+func completion(ctx context.Context, args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("expected a single shell argument, one of: %s", strings.Join(completionShells, ", "))
+	}
+
+	commands := describeCommands(rootCmd)
+
+	switch args[0] {
+	case "bash":
+		return writeBashCompletion(os.Stdout, commands)
+	case "zsh":
+		return writeZshCompletion(os.Stdout, commands)
+	case "fish":
+		return writeFishCompletion(os.Stdout, commands)
+	default:
+		return fmt.Errorf("unsupported shell %q, expected one of: %s", args[0], strings.Join(completionShells, ", "))
+	}
+}
+
+// Name of the shell function implementing the completion
+func completionFunction() string {
+	return "_" + strings.NewReplacer("-", "_", ".", "_").Replace(binName)
+}
+
+func writeBashCompletion(w io.Writer, commands []commandInfo) error {
+	root := commands[0]
+	subcommands := commands[1:]
+
+	var names []string
+	for _, cmd := range subcommands {
+		names = append(names, cmd.name)
+	}
+
+	words := func(cmd commandInfo) []string {
+		var words []string
+		for _, f := range cmd.flags {
+			words = append(words, "--"+f.name)
+		}
+		return append(words, cmd.args...)
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "# bash completion for %s\n\n", binName)
+	fmt.Fprintf(&b, "%s() {\n", completionFunction())
+	b.WriteString("\tlocal cur cmd words i\n")
+	b.WriteString("\tcur=\"${COMP_WORDS[COMP_CWORD]}\"\n")
+	b.WriteString("\tcmd=\"\"\n")
+	b.WriteString("\tfor ((i = 1; i < COMP_CWORD; i++)); do\n")
+	b.WriteString("\t\tcase \"${COMP_WORDS[i]}\" in\n")
+	fmt.Fprintf(&b, "\t\t%s)\n", strings.Join(names, "|"))
+	b.WriteString("\t\t\tcmd=\"${COMP_WORDS[i]}\"\n")
+	b.WriteString("\t\t\tbreak\n")
+	b.WriteString("\t\t\t;;\n")
+	b.WriteString("\t\tesac\n")
+	b.WriteString("\tdone\n\n")
+	b.WriteString("\tcase \"$cmd\" in\n")
+	for _, cmd := range subcommands {
+		fmt.Fprintf(&b, "\t%s)\n", cmd.name)
+		fmt.Fprintf(&b, "\t\twords=\"%s\"\n", strings.Join(append(words(cmd), words(root)...), " "))
+		b.WriteString("\t\t;;\n")
+	}
+	b.WriteString("\t*)\n")
+	fmt.Fprintf(&b, "\t\twords=\"%s\"\n", strings.Join(append(words(root), names...), " "))
+	b.WriteString("\t\t;;\n")
+	b.WriteString("\tesac\n\n")
+	b.WriteString("\tCOMPREPLY=($(compgen -W \"$words\" -- \"$cur\"))\n")
+	b.WriteString("}\n\n")
+	fmt.Fprintf(&b, "complete -o default -F %s %s\n", completionFunction(), binName)
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// Escape text for use inside a single quoted zsh _arguments spec
+var zshEscaper = strings.NewReplacer(
+	`'`, `'\''`,
+	`[`, `\[`,
+	`]`, `\]`,
+	`:`, `\:`,
+)
+
+func writeZshCompletion(w io.Writer, commands []commandInfo) error {
+	root := commands[0]
+	subcommands := commands[1:]
+
+	specs := func(cmd commandInfo) []string {
+		var specs []string
+		for _, f := range cmd.flags {
+			if f.value {
+				specs = append(specs, fmt.Sprintf("'--%s=[%s]:value:_default'", f.name, zshEscaper.Replace(f.usage)))
+			} else {
+				specs = append(specs, fmt.Sprintf("'--%s[%s]'", f.name, zshEscaper.Replace(f.usage)))
+			}
+		}
+		if len(cmd.args) > 0 {
+			specs = append(specs, fmt.Sprintf("'1:argument:(%s)'", strings.Join(cmd.args, " ")))
+		}
+		return specs
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "#compdef %s\n\n", binName)
+	fmt.Fprintf(&b, "%s() {\n", completionFunction())
+	b.WriteString("\tlocal cmd i\n")
+	b.WriteString("\tfor ((i = 2; i < CURRENT; i++)); do\n")
+	b.WriteString("\t\tcase \"${words[i]}\" in\n")
+	var names []string
+	for _, cmd := range subcommands {
+		names = append(names, cmd.name)
+	}
+	fmt.Fprintf(&b, "\t\t%s)\n", strings.Join(names, "|"))
+	b.WriteString("\t\t\tcmd=\"${words[i]}\"\n")
+	b.WriteString("\t\t\tbreak\n")
+	b.WriteString("\t\t\t;;\n")
+	b.WriteString("\t\tesac\n")
+	b.WriteString("\tdone\n\n")
+	b.WriteString("\tif [[ -n \"$cmd\" ]]; then\n")
+	b.WriteString("\t\tshift $((i - 1)) words\n")
+	b.WriteString("\t\t(( CURRENT -= i - 1 ))\n")
+	b.WriteString("\tfi\n\n")
+	b.WriteString("\tcase \"$cmd\" in\n")
+	for _, cmd := range subcommands {
+		fmt.Fprintf(&b, "\t%s)\n", cmd.name)
+		fmt.Fprintf(&b, "\t\t_arguments \\\n\t\t\t%s\n", strings.Join(append(specs(cmd), specs(root)...), " \\\n\t\t\t"))
+		b.WriteString("\t\t;;\n")
+	}
+	var described []string
+	for _, cmd := range subcommands {
+		help := strings.NewReplacer(`\`, `\\`, `"`, `\"`, `:`, `\:`, `'`, `'\''`).Replace(cmd.help)
+		described = append(described, fmt.Sprintf(`%s\:"%s"`, cmd.name, help))
+	}
+	b.WriteString("\t*)\n")
+	fmt.Fprintf(&b, "\t\t_arguments \\\n\t\t\t%s \\\n\t\t\t'1:command:((%s))'\n", strings.Join(specs(root), " \\\n\t\t\t"), strings.Join(described, " "))
+	b.WriteString("\t\t;;\n")
+	b.WriteString("\tesac\n")
+	b.WriteString("}\n\n")
+	fmt.Fprintf(&b, "%s \"$@\"\n", completionFunction())
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// Quote text as a single quoted fish string
+func fishQuote(s string) string {
+	return "'" + strings.NewReplacer(`\`, `\\`, `'`, `\'`).Replace(s) + "'"
+}
+
+func writeFishCompletion(w io.Writer, commands []commandInfo) error {
+	root := commands[0]
+	subcommands := commands[1:]
+
+	flag := func(b *strings.Builder, condition string, f flagInfo) {
+		fmt.Fprintf(b, "complete -c %s", binName)
+		if condition != "" {
+			fmt.Fprintf(b, " -n %s", fishQuote(condition))
+		}
+		fmt.Fprintf(b, " -l %s", f.name)
+		if f.value {
+			b.WriteString(" -r")
+		}
+		fmt.Fprintf(b, " -d %s\n", fishQuote(f.usage))
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "# fish completion for %s\n\n", binName)
+	for _, f := range root.flags {
+		flag(&b, "", f)
+	}
+
+	for _, cmd := range subcommands {
+		b.WriteString("\n")
+		fmt.Fprintf(&b, "complete -c %s -f -n '__fish_use_subcommand' -a %s -d %s\n", binName, cmd.name, fishQuote(cmd.help))
+
+		condition := "__fish_seen_subcommand_from " + cmd.name
+		for _, f := range cmd.flags {
+			flag(&b, condition, f)
+		}
+		if len(cmd.args) > 0 {
+			fmt.Fprintf(&b, "complete -c %s -f -n %s -a %s\n", binName, fishQuote(condition), fishQuote(strings.Join(cmd.args, " ")))
+		}
+	}
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
@@ -0,0 +1,210 @@
+package main
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"os"
+	"sync"
+
+	"github.com/peterbourgon/ff/v4"
+	"github.com/prometheus/common/version"
+)
+
+// The validators of a downloaded registry, sent with the next request for it
+// so that the server can answer 304 Not Modified if it hasn't changed
+type cacheValidator struct {
+	etag         string
+	lastModified string
+	sha256       string
+}
+
+// A cache validator as persisted in the validator file
+type persistedValidator struct {
+	ETag         string `json:"etag,omitempty"`
+	LastModified string `json:"last_modified,omitempty"`
+	SHA256       string `json:"sha256"`
+}
+
+// The validator file, which keeps the validators and the change detection
+// state next to the metric file across restarts and --oneshot runs
+type validatorState struct {
+	// Hash of the configuration which produced the metric file, as the
+	// metric file has to be generated again if it changes
+	Fingerprint      string                        `json:"fingerprint"`
+	Validators       map[string]persistedValidator `json:"validators"`
+	SourceSHA256     string                        `json:"source_sha256"`
+	UnchangedFetches int                           `json:"unchanged_fetches"`
+}
+
+var (
+	// Validators of the registries downloaded by the last successful
+	// refresh, keyed by URL
+	cacheValidators      = map[string]cacheValidator{}
+	cacheValidatorsMutex sync.Mutex
+)
+
+// Make a request conditional on the registry at its URL having changed since
+// the last successful refresh
+func setConditionalHeaders(req *http.Request, source string) {
+	cacheValidatorsMutex.Lock()
+	defer cacheValidatorsMutex.Unlock()
+
+	v, ok := cacheValidators[source]
+	if !ok {
+		return
+	}
+
+	if v.etag != "" {
+		req.Header.Set("If-None-Match", v.etag)
+	}
+
+	if v.lastModified != "" {
+		req.Header.Set("If-Modified-Since", v.lastModified)
+	}
+}
+
+// The validators remembered for the registry at a URL
+func cachedValidator(source string) cacheValidator {
+	cacheValidatorsMutex.Lock()
+	defer cacheValidatorsMutex.Unlock()
+
+	return cacheValidators[source]
+}
+
+// Remember the validators of the registries of a download once it has been
+// turned into metrics
+func rememberValidators(dl download) {
+	cacheValidatorsMutex.Lock()
+	defer cacheValidatorsMutex.Unlock()
+
+	cacheValidators[dl.url] = dl.validator
+
+	for _, r := range dl.registries {
+		cacheValidators[r.registry.url] = r.validator
+	}
+}
+
+// Forget the validators of a registry, so that the next request for it
+// downloads it in full
+func forgetValidator(source string) {
+	cacheValidatorsMutex.Lock()
+	defer cacheValidatorsMutex.Unlock()
+
+	delete(cacheValidators, source)
+}
+
+// Forget the validators of all registries, so that the next refresh downloads
+// them in full
+func forgetValidators() {
+	cacheValidatorsMutex.Lock()
+	defer cacheValidatorsMutex.Unlock()
+
+	clear(cacheValidators)
+}
+
+// The validator file kept next to the metric file, if there is one
+func validatorFile() string {
+	if *metricFile == "" || isFIFO(*metricFile) {
+		return ""
+	}
+
+	return *metricFile + ".validators.json"
+}
+
+// Hash the collector version and the flag values, which determine what a
+// downloaded OUI database is turned into
+func configFingerprint() string {
+	hash := sha256.New()
+	fmt.Fprintf(hash, "%s\n", version.Version)
+
+	rootCmd.Flags.WalkFlags(func(f ff.Flag) error {
+		name, _ := f.GetLongName()
+		fmt.Fprintf(hash, "%s=%s\n", name, f.GetValue())
+
+		return nil
+	})
+
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+// Write the validators of the last successful refresh and the change
+// detection state to the validator file, if enabled
+func saveValidators() error {
+	filename := validatorFile()
+	if filename == "" {
+		return nil
+	}
+
+	state := validatorState{
+		Fingerprint:      configFingerprint(),
+		Validators:       map[string]persistedValidator{},
+		SourceSHA256:     previousSourceSHA256,
+		UnchangedFetches: unchangedFetches,
+	}
+
+	cacheValidatorsMutex.Lock()
+	for source, v := range cacheValidators {
+		state.Validators[source] = persistedValidator{ETag: v.etag, LastModified: v.lastModified, SHA256: v.sha256}
+	}
+	cacheValidatorsMutex.Unlock()
+
+	return writeFileAtomic(filename, func(w *bufio.Writer) error {
+		return json.NewEncoder(w).Encode(state)
+	})
+}
+
+// Load the validators and the change detection state of the previous run from
+// the validator file. They are ignored if the metric file is missing or was
+// generated with another configuration, so that it is generated again.
+func loadValidators() error {
+	filename := validatorFile()
+	if filename == "" {
+		return nil
+	}
+
+	if _, err := os.Stat(*metricFile); err != nil {
+		return nil
+	}
+
+	content, err := os.ReadFile(filename)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+
+	if err != nil {
+		return fmt.Errorf("error reading validator file: %w", err)
+	}
+
+	state := validatorState{}
+	if err := json.Unmarshal(content, &state); err != nil {
+		return fmt.Errorf("error parsing validator file: %w", err)
+	}
+
+	if state.Fingerprint != configFingerprint() {
+		return nil
+	}
+
+	cacheValidatorsMutex.Lock()
+	defer cacheValidatorsMutex.Unlock()
+
+	for source, v := range state.Validators {
+		cacheValidators[source] = cacheValidator{etag: v.ETag, lastModified: v.LastModified, sha256: v.SHA256}
+	}
+
+	previousSourceSHA256 = state.SourceSHA256
+	unchangedFetches = state.UnchangedFetches
+
+	return nil
+}
+
+// Check whether outputs are built from the loaded OUI database between
+// refreshes: the seen-only subset, merged fragments, the exporter and pushes
+func needsLoadedDatabase() bool {
+	return seenOnly() || *mergeDir != "" || *exporterListen != "" || *pushgatewayURL != ""
+}
@@ -0,0 +1,211 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/peterbourgon/ff/v4"
+)
+
+var (
+	// The content of the config file which was last applied, restored when
+	// a reloaded config file is invalid
+	configContent []byte
+
+	// Held for writing while the config file is reloaded, which parses the
+	// flags again, and for reading by goroutines which read flags while the
+	// collector loop is running
+	configMutex sync.RWMutex
+)
+
+// Flags which are only applied at startup. A reloaded config file which
+// changes them is rejected.
+var startupFlags = []string{
+	"job",
+	"log-format",
+	"log-file",
+	"oneshot",
+	"exporter-listen",
+	"exporter-socket-mode",
+	"sflow-listen",
+	"capture-interface",
+	"enrichment-interval",
+	"time-scale",
+	"jitter-seed",
+	"profile-cpu",
+	"profile-mem",
+}
+
+// The values of the startup flags
+func startupFlagValues() map[string]string {
+	values := map[string]string{}
+
+	for _, name := range startupFlags {
+		if f, ok := rootCmd.Flags.GetFlag(name); ok {
+			values[name] = f.GetValue()
+		}
+	}
+
+	return values
+}
+
+// Check that the flags which are only applied at startup kept their values
+func checkStartupFlags(previous map[string]string) error {
+	errs := []error{}
+
+	for name, value := range startupFlagValues() {
+		if value != previous[name] {
+			errs = append(errs, fmt.Errorf("--%s can't be changed without a restart", name))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// Options for parsing the flags, which are set from the command line, then
+// the environment, then the config file
+func parseOptions() []ff.Option {
+	return []ff.Option{
+		ff.WithEnvVarPrefix(strings.ToUpper(binName)),
+		ff.WithEnvVarSplit(" "),
+		ff.WithConfigFileFlag("config"),
+		ff.WithConfigFileParser(expandEnvParser(parseConfigFile)),
+	}
+}
+
+// A ${VAR} reference to an environment variable in a config file value
+var envReference = regexp.MustCompile(`\$\{([A-Za-z_][A-Za-z0-9_]*)\}`)
+
+// Expand the ${VAR} references to environment variables in a config file
+// value, so that e.g. secrets can be injected by systemd or Kubernetes. Other
+// uses of $, e.g. in regular expressions, are kept. A reference to an unset
+// variable is an error rather than an empty value.
+func expandEnv(value string) (string, error) {
+	errs := []error{}
+
+	expanded := envReference.ReplaceAllStringFunc(value, func(ref string) string {
+		name := envReference.FindStringSubmatch(ref)[1]
+
+		v, ok := os.LookupEnv(name)
+		if !ok {
+			errs = append(errs, fmt.Errorf("environment variable %s is not set", name))
+		}
+
+		return v
+	})
+
+	return expanded, errors.Join(errs...)
+}
+
+// Wrap a config file parser to expand environment variables in its values
+func expandEnvParser(parse ff.ConfigFileParseFunc) ff.ConfigFileParseFunc {
+	return func(r io.Reader, set func(name, value string) error) error {
+		return parse(r, func(name, value string) error {
+			expanded, err := expandEnv(value)
+			if err != nil {
+				return fmt.Errorf("error expanding %s: %w", name, err)
+			}
+
+			return set(name, expanded)
+		})
+	}
+}
+
+// A filesystem which holds a config file read earlier, whatever its name
+type configSnapshot []byte
+
+func (c configSnapshot) Open(name string) (fs.File, error) {
+	return configSnapshotFile{bytes.NewReader(c)}, nil
+}
+
+type configSnapshotFile struct {
+	*bytes.Reader
+}
+
+func (f configSnapshotFile) Stat() (fs.FileInfo, error) {
+	return nil, errors.ErrUnsupported
+}
+
+func (f configSnapshotFile) Close() error {
+	return nil
+}
+
+// Parse the flags again with the given content of the config file
+func applyConfig(content []byte) error {
+	return applyJobConfig(content, "")
+}
+
+// Parse the flags again with the given content of the config file, applying
+// the settings of a job of the config file as its process does
+func applyJobConfig(content []byte, job string) error {
+	if err := rootCmd.Reset(); err != nil {
+		return err
+	}
+
+	args := os.Args[1:]
+	if job != "" {
+		args = append(slices.Clone(args), "--job", job)
+	}
+
+	options := append(parseOptions(), ff.WithFilesystem(configSnapshot(content)))
+
+	return rootCmd.Parse(args, options...)
+}
+
+// Read the config file again and apply it, rebuilding the HTTP clients. If it
+// can't be parsed, holds an invalid configuration or changes a flag which is
+// only applied at startup, the previous config file is applied again. The
+// caller must stop the goroutines which read flags without the config lock.
+func reloadConfig() error {
+	configMutex.Lock()
+	defer configMutex.Unlock()
+
+	content, err := os.ReadFile(*configFile)
+	if err != nil {
+		return fmt.Errorf("error reading config file: %w", err)
+	}
+
+	startup := startupFlagValues()
+	previousClients := []*http.Client{httpClient, controllerClient}
+
+	err = applyConfig(content)
+	if err == nil {
+		err = validateFlags()
+	}
+
+	if err == nil {
+		err = checkStartupFlags(startup)
+	}
+
+	// The clients are only replaced once they have been built
+	if err == nil {
+		err = setupHTTPClients()
+	}
+
+	if err != nil {
+		if err := applyConfig(configContent); err != nil {
+			return fmt.Errorf("error restoring previous config file: %w", err)
+		}
+
+		return fmt.Errorf("invalid config file, keeping the previous configuration: %w", err)
+	}
+
+	configContent = content
+	setLogLevel()
+
+	// Requests in flight keep the connections they were started with
+	for _, client := range previousClients {
+		client.CloseIdleConnections()
+	}
+
+	return nil
+}
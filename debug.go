@@ -0,0 +1,65 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"runtime/pprof"
+	"time"
+)
+
+// Runtime state of the refresh loop
+type collectorState struct {
+	nextRefresh time.Time
+	retries     int
+	lastError   error
+	entries     int
+	health      collectorHealth
+}
+
+// Write the runtime state of the collector followed by the stacks of all
+// goroutines, so that a collector which seems stuck can be inspected without
+// restarting it
+func dumpState(w io.Writer, state collectorState) error {
+	timestamp := func(t time.Time) string {
+		if t.IsZero() {
+			return "never"
+		}
+
+		return t.Format(time.RFC3339)
+	}
+
+	lastError := "none"
+	if state.lastError != nil {
+		lastError = state.lastError.Error()
+	}
+
+	paths := []struct {
+		name string
+		path string
+	}{
+		{"output file", *metricFile},
+		{"temporary directory", *tempDir},
+		{"health file", *healthFile},
+		{"checkmk file", *checkmkFile},
+		{"organization hash file", *organizationHashFile},
+	}
+
+	fmt.Fprintf(w, "=== %s state ===\n", binName)
+	fmt.Fprintf(w, "next refresh: %s (in %s)\n", timestamp(state.nextRefresh), time.Until(state.nextRefresh).Round(time.Second))
+	fmt.Fprintf(w, "retries: %d\n", state.retries)
+	fmt.Fprintf(w, "last success: %s\n", timestamp(state.health.lastSuccess))
+	fmt.Fprintf(w, "last failure: %s\n", timestamp(state.health.lastFailure))
+	fmt.Fprintf(w, "last error: %s\n", lastError)
+	fmt.Fprintf(w, "entries: %d\n", state.entries)
+	fmt.Fprintf(w, "records: %d\n", len(ouiRecords))
+
+	for _, p := range paths {
+		if p.path != "" {
+			fmt.Fprintf(w, "%s: %s\n", p.name, p.path)
+		}
+	}
+
+	fmt.Fprintf(w, "=== goroutines ===\n")
+
+	return pprof.Lookup("goroutine").WriteTo(w, 2)
+}
@@ -0,0 +1,272 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// An active DHCP lease
+type dhcpLease struct {
+	ip       string
+	mac      net.HardwareAddr
+	hostname string
+}
+
+// Parse an ISC dhcpd lease file. Later declarations of a lease supersede
+// earlier ones, and only leases in the active binding state are returned.
+func parseISCLeases(r io.Reader) ([]dhcpLease, error) {
+	leases := map[string]dhcpLease{}
+	active := map[string]bool{}
+
+	var (
+		current  *dhcpLease
+		isActive bool
+		hasState bool
+	)
+
+	scanner := bufio.NewScanner(r)
+
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		if current == nil {
+			if ip, ok := strings.CutPrefix(line, "lease "); ok {
+				ip, _, _ = strings.Cut(ip, " ")
+				current = &dhcpLease{ip: ip}
+				isActive = false
+				hasState = false
+			}
+
+			continue
+		}
+
+		if line == "}" {
+			// Leases without a binding state are from old servers which only
+			// wrote active leases
+			leases[current.ip] = *current
+			active[current.ip] = isActive || !hasState
+			current = nil
+
+			continue
+		}
+
+		fields := strings.Fields(strings.TrimSuffix(line, ";"))
+
+		switch {
+		case len(fields) >= 3 && fields[0] == "binding" && fields[1] == "state":
+			hasState = true
+			isActive = fields[2] == "active"
+		case len(fields) >= 3 && fields[0] == "hardware" && fields[1] == "ethernet":
+			mac, err := net.ParseMAC(fields[2])
+			if err == nil {
+				current.mac = mac
+			}
+		case len(fields) >= 2 && fields[0] == "client-hostname":
+			current.hostname = strings.Trim(strings.Join(fields[1:], " "), `"`)
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("error reading ISC dhcpd lease file: %w", err)
+	}
+
+	result := []dhcpLease{}
+
+	for ip, lease := range leases {
+		if active[ip] && lease.mac != nil {
+			result = append(result, lease)
+		}
+	}
+
+	return result, nil
+}
+
+// Parse a dnsmasq lease file, which has one "expiry mac ip hostname client-id"
+// line per lease
+func parseDnsmasqLeases(r io.Reader) ([]dhcpLease, error) {
+	result := []dhcpLease{}
+	scanner := bufio.NewScanner(r)
+
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+
+		// IPv6 leases are preceded by a "duid" line
+		if len(fields) < 4 || fields[0] == "duid" {
+			continue
+		}
+
+		mac, err := net.ParseMAC(fields[1])
+		if err != nil {
+			continue
+		}
+
+		hostname := fields[3]
+		if hostname == "*" {
+			hostname = ""
+		}
+
+		result = append(result, dhcpLease{
+			ip:       fields[2],
+			mac:      mac,
+			hostname: hostname,
+		})
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("error reading dnsmasq lease file: %w", err)
+	}
+
+	return result, nil
+}
+
+// Parse a Kea memfile lease CSV file. Later rows for a lease supersede earlier
+// ones, and only unexpired leases in the default state are returned.
+func parseKeaLeases(r io.Reader) ([]dhcpLease, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+
+	header, err := reader.Read()
+	if err != nil {
+		return nil, fmt.Errorf("error reading Kea lease file header: %w", err)
+	}
+
+	columns := map[string]int{}
+	for i, name := range header {
+		columns[name] = i
+	}
+
+	for _, name := range []string{"address", "hwaddr", "expire", "hostname", "state"} {
+		if _, exists := columns[name]; !exists {
+			return nil, fmt.Errorf("missing column %q in Kea lease file", name)
+		}
+	}
+
+	leases := map[string]dhcpLease{}
+	now := time.Now().Unix()
+
+	for {
+		row, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+
+		if err != nil {
+			return nil, fmt.Errorf("error parsing Kea lease file: %w", err)
+		}
+
+		if len(row) < len(header) {
+			continue
+		}
+
+		ip := row[columns["address"]]
+		expire, _ := strconv.ParseInt(row[columns["expire"]], 10, 64)
+		mac, err := net.ParseMAC(row[columns["hwaddr"]])
+
+		if err != nil || row[columns["state"]] != "0" || expire < now {
+			delete(leases, ip)
+
+			continue
+		}
+
+		leases[ip] = dhcpLease{
+			ip:       ip,
+			mac:      mac,
+			hostname: strings.TrimSuffix(row[columns["hostname"]], "."),
+		}
+	}
+
+	result := []dhcpLease{}
+	for _, lease := range leases {
+		result = append(result, lease)
+	}
+
+	return result, nil
+}
+
+// Read the active leases from a DHCP server lease file
+func readDHCPLeases(filename string, format string) ([]dhcpLease, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, fmt.Errorf("error opening DHCP lease file: %w", err)
+	}
+	defer f.Close()
+
+	switch format {
+	case "isc":
+		return parseISCLeases(f)
+	case "dnsmasq":
+		return parseDnsmasqLeases(f)
+	case "kea":
+		return parseKeaLeases(f)
+	}
+
+	return nil, fmt.Errorf("unknown DHCP lease file format %q", format)
+}
+
+// Build vendor samples for a set of DHCP leases
+func dhcpSamples(ouiMap map[string]string, leases []dhcpLease) []sample {
+	slices.SortFunc(leases, func(a, b dhcpLease) int {
+		return strings.Compare(a.ip, b.ip)
+	})
+
+	samples := make([]sample, 0, len(leases))
+	macs := make([]net.HardwareAddr, 0, len(leases))
+
+	for _, lease := range leases {
+		macs = append(macs, lease.mac)
+		samples = append(samples, vendorSample(
+			*dhcpMetricName,
+			ouiMap,
+			lease.mac,
+			label{name: "ip", value: lease.ip},
+			label{name: "hostname", value: lease.hostname},
+		))
+	}
+
+	groups := map[string][]net.HardwareAddr{"": macs}
+
+	return append(samples, randomizationSamples("dhcp", "", groups)...)
+}
+
+// Poll the DHCP leases for the DHCP lease metric file, if enabled and the OUI
+// database is loaded
+func pollDHCP(ctx context.Context, ouiMap map[string]string) (enrichmentOutput, bool) {
+	if *dhcpFile == "" || ouiMap == nil {
+		return enrichmentOutput{}, false
+	}
+
+	var (
+		leases []dhcpLease
+		err    error
+	)
+
+	switch {
+	case *keaAPIURL != "":
+		leases, err = readKeaLeases(ctx, *keaAPIURL)
+	case *dhcpLeasesFile != "":
+		leases, err = readDHCPLeases(*dhcpLeasesFile, *dhcpLeasesFormat)
+	default:
+		return enrichmentOutput{}, false
+	}
+
+	if err != nil {
+		slog.Error("Error reading DHCP leases", "error", err.Error())
+
+		return enrichmentOutput{}, false
+	}
+
+	return enrichmentOutput{filename: *dhcpFile, samples: dhcpSamples(ouiMap, leases)}, true
+}
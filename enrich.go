@@ -0,0 +1,150 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net"
+	"slices"
+	"sync"
+)
+
+// Format the OUI of a MAC address the same way as in the OUI database
+func macOUI(mac net.HardwareAddr) string {
+	return fmt.Sprintf("%02x:%02x:%02x", mac[0], mac[1], mac[2])
+}
+
+// Build a vendor info sample for a MAC address
+func vendorSample(name string, ouiMap map[string]string, mac net.HardwareAddr, labels ...label) sample {
+	oui := macOUI(mac)
+
+	return sample{
+		name: name,
+		labels: append([]label{
+			{name: "mac", value: mac.String()},
+			{name: "oui", value: oui},
+			{name: "organization_name", value: ouiMap[oui]},
+		}, labels...),
+		value: 1,
+	}
+}
+
+// Check whether a MAC address is locally administered, which randomized MAC
+// addresses always are
+func isLocallyAdministered(mac net.HardwareAddr) bool {
+	return mac[0]&0x02 != 0
+}
+
+// Build samples of the number of observed MAC addresses and the share of them
+// which are locally administered, per group. The group label is omitted when
+// groupLabel is empty.
+func randomizationSamples(source string, groupLabel string, groups map[string][]net.HardwareAddr) []sample {
+	samples := []sample{}
+
+	for group, macs := range groups {
+		if len(macs) == 0 {
+			continue
+		}
+
+		local := 0
+		for _, mac := range macs {
+			if isLocallyAdministered(mac) {
+				local++
+			}
+		}
+
+		labels := []label{{name: "source", value: source}}
+		if groupLabel != "" {
+			labels = append(labels, label{name: groupLabel, value: group})
+		}
+
+		samples = append(samples,
+			sample{
+				name:   "mac_observed_addresses",
+				labels: labels,
+				value:  float64(len(macs)),
+			},
+			sample{
+				name:   "mac_locally_administered_ratio",
+				labels: labels,
+				value:  float64(local) / float64(len(macs)),
+			},
+		)
+	}
+
+	return samples
+}
+
+// Check whether any enrichment metric file, or anything else which is
+// refreshed at the enrichment interval, is enabled
+func enrichmentEnabled() bool {
+	enrichment := []string{
+		*neighborFile,
+		*dhcpFile,
+		*captureFile,
+		*snmpFile,
+		*wifiFile,
+		*sflowFile,
+		*nicFile,
+	}
+
+	return slices.ContainsFunc(enrichment, func(f string) bool { return f != "" }) || seenOnly() || *mergeDir != ""
+}
+
+// Update the enabled enrichment metric files of local sources
+func reportEnrichment(ouiMap map[string]string) {
+	reportNeighbors(ouiMap)
+	reportCapture(ouiMap)
+	reportSFlow(ouiMap)
+	reportNICs(ouiMap)
+}
+
+// Samples polled for an enrichment metric file
+type enrichmentOutput struct {
+	filename string
+	samples  []sample
+}
+
+// Poll the enabled enrichment sources which are queried over the network:
+// DHCP servers, switches over SNMP and wireless controllers. Slow or
+// unreachable devices can take long enough to hold back refreshes and signals,
+// so the polls run in the background, are cancelled with ctx and hand their
+// samples back to be written.
+func pollEnrichment(ctx context.Context, ouiMap map[string]string) []enrichmentOutput {
+	polls := []func(context.Context, map[string]string) (enrichmentOutput, bool){pollDHCP, pollSNMP, pollWifi}
+	results := make([]enrichmentOutput, len(polls))
+	enabled := make([]bool, len(polls))
+
+	// Poll the sources concurrently, so that a poll takes as long as the
+	// slowest source rather than all of them together
+	var wg sync.WaitGroup
+	for i, poll := range polls {
+		wg.Go(func() {
+			results[i], enabled[i] = poll(ctx, ouiMap)
+		})
+	}
+	wg.Wait()
+
+	outputs := []enrichmentOutput{}
+	for i, output := range results {
+		if enabled[i] {
+			outputs = append(outputs, output)
+		}
+	}
+
+	// A cancelled poll is incomplete
+	if ctx.Err() != nil {
+		return nil
+	}
+
+	return outputs
+}
+
+// Write the polled enrichment metric files
+func writeEnrichment(outputs []enrichmentOutput) {
+	for _, output := range outputs {
+		if err := writeMetrics(output.filename, output.samples); err != nil {
+			slog.Error("Error writing enrichment metric file", "file", output.filename, "error", err.Error())
+		}
+	}
+}
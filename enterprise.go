@@ -0,0 +1,62 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"log/slog"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Parse the IANA Private Enterprise Numbers registry, which lists each
+// enterprise number on its own line followed by the organization indented
+// with two spaces
+func parseEnterpriseNumbers(filename string, logger *slog.Logger) ([]sample, error) {
+	input, err := os.Open(filename)
+	if err != nil {
+		return nil, fmt.Errorf("error opening enterprise numbers file: %w", err)
+	}
+	defer input.Close()
+
+	samples := []sample{}
+	id := ""
+
+	scanner := bufio.NewScanner(input)
+
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), " \r")
+
+		if _, err := strconv.ParseUint(line, 10, 32); err == nil {
+			id = line
+
+			continue
+		}
+
+		if id == "" || !strings.HasPrefix(line, "  ") || strings.HasPrefix(line, "   ") {
+			continue
+		}
+
+		organization := strings.TrimSpace(line)
+		if organization == "" || organization == "---none---" {
+			logger.Debug("Ignoring enterprise number without organization", "enterprise_id", id)
+		} else {
+			samples = append(samples, sample{
+				name: *enterpriseMetricName,
+				labels: []label{
+					{name: "enterprise_id", value: id},
+					{name: "organization", value: organization},
+				},
+				value: 1,
+			})
+		}
+
+		id = ""
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, withClass("parse", fmt.Errorf("error reading enterprise numbers file: %w", err))
+	}
+
+	return samples, nil
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"net"
+)
+
+// An error which has been assigned a class for the collector health metrics
+type classifiedError struct {
+	class string
+	err   error
+}
+
+func (e classifiedError) Error() string {
+	return e.err.Error()
+}
+
+func (e classifiedError) Unwrap() error {
+	return e.err
+}
+
+// Assign a class to an error
+func withClass(class string, err error) error {
+	return classifiedError{class: class, err: err}
+}
+
+// Find the class of an error, falling back to the given class if the error
+// can't be classified
+func errorClass(err error, fallback string) string {
+	var classified classifiedError
+	if errors.As(err, &classified) {
+		return classified.class
+	}
+
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) {
+		return "dns"
+	}
+
+	var (
+		alertErr       tls.AlertError
+		recordErr      tls.RecordHeaderError
+		verifyErr      *tls.CertificateVerificationError
+		authorityErr   x509.UnknownAuthorityError
+		hostnameErr    x509.HostnameError
+		certInvalidErr x509.CertificateInvalidError
+	)
+	if errors.As(err, &alertErr) || errors.As(err, &recordErr) || errors.As(err, &verifyErr) ||
+		errors.As(err, &authorityErr) || errors.As(err, &hostnameErr) || errors.As(err, &certInvalidErr) {
+		return "tls"
+	}
+
+	var opErr *net.OpError
+	if errors.As(err, &opErr) && opErr.Op == "dial" {
+		return "connect"
+	}
+
+	return fallback
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Find the index of the first of the named columns present in a CSV header
+func csvColumn(header []string, names ...string) int {
+	for _, name := range names {
+		for i, column := range header {
+			if strings.EqualFold(strings.TrimSpace(column), name) {
+				return i
+			}
+		}
+	}
+
+	return -1
+}
+
+// Parse the IEEE EtherType registry CSV file
+func parseEtherTypes(filename string, logger *slog.Logger) ([]sample, error) {
+	input, err := os.Open(filename)
+	if err != nil {
+		return nil, fmt.Errorf("error opening EtherType CSV file: %w", err)
+	}
+	defer input.Close()
+
+	reader := csv.NewReader(input)
+	reader.FieldsPerRecord = -1
+
+	header, err := reader.Read()
+	if err != nil {
+		return nil, withClass("parse", fmt.Errorf("error reading EtherType CSV header: %w", err))
+	}
+
+	assignment := csvColumn(header, "Assignment")
+	description := csvColumn(header, "Protocol", "Organization Name")
+
+	if assignment < 0 || description < 0 {
+		return nil, withClass("validation", errors.New("EtherType CSV header lacks an assignment or description column"))
+	}
+
+	samples := []sample{}
+
+	for {
+		entry, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+
+		if err != nil {
+			return nil, withClass("parse", fmt.Errorf("error parsing EtherType CSV file: %w", err))
+		}
+
+		if len(entry) <= max(assignment, description) {
+			continue
+		}
+
+		hex := strings.NewReplacer("-", "", "0x", "").Replace(strings.ToLower(strings.TrimSpace(entry[assignment])))
+		if _, err := strconv.ParseUint(hex, 16, 16); err != nil || len(hex) != 4 {
+			logger.Error("EtherType has wrong format", "ethertype", entry[assignment])
+
+			continue
+		}
+
+		samples = append(samples, sample{
+			name: *ethertypeMetricName,
+			labels: []label{
+				{name: "ethertype", value: "0x" + hex},
+				{name: "description", value: strings.TrimSpace(entry[description])},
+			},
+			value: 1,
+		})
+	}
+
+	return samples, nil
+}
@@ -0,0 +1,266 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"mime"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/expfmt"
+	"google.golang.org/protobuf/proto"
+)
+
+var (
+	// Samples of the metric file served by the exporter
+	exportedSamples []sample
+	exportedMutex   sync.RWMutex
+)
+
+// Replace the samples served by the exporter
+func exportSamples(samples []sample) {
+	exportedMutex.Lock()
+	defer exportedMutex.Unlock()
+
+	exportedSamples = samples
+}
+
+// Registry of the Go runtime and process metrics of the collector, which the
+// exporter serves along with the metric file
+var runtimeRegistry = newRuntimeRegistry()
+
+func newRuntimeRegistry() *prometheus.Registry {
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	)
+
+	return registry
+}
+
+// Render the samples of the metric file and the runtime metrics in the format
+// negotiated with the Accept header of a scrape. The format of the metric
+// file is rendered as in the metric file, and is also served to scrapers
+// which don't name a format, e.g. with Accept: */*. Other formats are encoded
+// by expfmt. The flags are read under the config lock, as the config file may
+// be reloaded while a scrape is served.
+func renderMetrics(header http.Header) ([]byte, expfmt.Format, error) {
+	runtimeFamilies, err := runtimeRegistry.Gather()
+	if err != nil {
+		return nil, "", err
+	}
+
+	configMutex.RLock()
+	defer configMutex.RUnlock()
+
+	exportedMutex.RLock()
+	defer exportedMutex.RUnlock()
+
+	format := expfmt.NewFormat(expfmt.TypeTextPlain)
+	if *openMetrics {
+		format = expfmt.NewFormat(expfmt.TypeOpenMetrics)
+	}
+
+	buf := bytes.Buffer{}
+
+	if namesFormat(header) {
+		negotiated := expfmt.NegotiateIncludingOpenMetrics(header)
+		if negotiated.FormatType() != format.FormatType() {
+			families := append(sampleFamilies(exportedSamples), runtimeFamilies...)
+			if err := encodeFamilies(&buf, families, negotiated); err != nil {
+				return nil, "", err
+			}
+
+			return buf.Bytes(), negotiated, nil
+		}
+	}
+
+	bw := bufio.NewWriter(&buf)
+
+	if _, err := writeSamples(bw, exportedSamples); err != nil {
+		return nil, "", err
+	}
+
+	for _, family := range runtimeFamilies {
+		if *openMetrics {
+			_, err = expfmt.MetricFamilyToOpenMetrics(bw, family)
+		} else {
+			_, err = expfmt.MetricFamilyToText(bw, family)
+		}
+
+		if err != nil {
+			return nil, "", err
+		}
+	}
+
+	if _, err := writeEOF(bw); err != nil {
+		return nil, "", err
+	}
+
+	if err := bw.Flush(); err != nil {
+		return nil, "", err
+	}
+
+	return buf.Bytes(), format, nil
+}
+
+// Check whether the Accept header of a scrape names an exposition format,
+// rather than accepting anything with */*
+func namesFormat(header http.Header) bool {
+	for _, accept := range strings.Split(header.Get("Accept"), ",") {
+		mediaType, _, err := mime.ParseMediaType(accept)
+		if err != nil {
+			continue
+		}
+
+		switch mediaType {
+		case "text/plain", expfmt.OpenMetricsType, expfmt.ProtoType:
+			return true
+		}
+	}
+
+	return false
+}
+
+// Group samples into metric families as in the metric file
+func sampleFamilies(samples []sample) []*dto.MetricFamily {
+	static := staticLabels()
+	families := []*dto.MetricFamily{}
+	index := map[string]*dto.MetricFamily{}
+
+	for _, s := range samples {
+		family, ok := index[s.name]
+		if !ok {
+			family = &dto.MetricFamily{Name: proto.String(s.name), Type: dto.MetricType_GAUGE.Enum()}
+			if metricType(s.name) == "counter" {
+				family.Type = dto.MetricType_COUNTER.Enum()
+			}
+
+			if help := metricHelpText(s.name); help != "" {
+				family.Help = proto.String(help)
+			}
+
+			families = append(families, family)
+			index[s.name] = family
+		}
+
+		metric := &dto.Metric{}
+		for _, l := range sampleOutputLabels(s, static) {
+			metric.Label = append(metric.Label, &dto.LabelPair{Name: proto.String(l.name), Value: proto.String(l.value)})
+		}
+
+		if family.GetType() == dto.MetricType_COUNTER {
+			metric.Counter = &dto.Counter{Value: proto.Float64(s.value)}
+		} else {
+			metric.Gauge = &dto.Gauge{Value: proto.Float64(s.value)}
+		}
+
+		family.Metric = append(family.Metric, metric)
+	}
+
+	return families
+}
+
+// Encode metric families in an exposition format with expfmt
+func encodeFamilies(w io.Writer, families []*dto.MetricFamily, format expfmt.Format) error {
+	encoder := expfmt.NewEncoder(w, format)
+
+	for _, family := range families {
+		if err := encoder.Encode(family); err != nil {
+			return err
+		}
+	}
+
+	if closer, ok := encoder.(expfmt.Closer); ok {
+		return closer.Close()
+	}
+
+	return nil
+}
+
+// Serve the samples of the metric file in the format negotiated with the
+// scraper
+func serveMetrics(w http.ResponseWriter, r *http.Request) {
+	body, format, err := renderMetrics(r.Header)
+	if err != nil {
+		slog.Error("Error rendering metrics response", "error", err.Error())
+		http.Error(w, "error rendering metrics", http.StatusInternalServerError)
+
+		return
+	}
+
+	w.Header().Set("Content-Type", string(format))
+
+	if _, err := w.Write(body); err != nil {
+		slog.Debug("Error writing metrics response", "error", err.Error())
+	}
+}
+
+// Listen on a TCP address, or on a Unix socket if the address is unix: and
+// its path. A socket left behind by a previous run is replaced, and the
+// socket gets the permissions of --exporter-socket-mode.
+func listenExporter(addr string) (net.Listener, error) {
+	path, ok := strings.CutPrefix(addr, "unix:")
+	if !ok {
+		return net.Listen("tcp", addr)
+	}
+
+	if info, err := os.Lstat(path); err == nil && info.Mode().Type() == fs.ModeSocket {
+		if err := os.Remove(path); err != nil {
+			return nil, err
+		}
+	}
+
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+
+	mode, _ := strconv.ParseUint(*exporterMode, 8, 32)
+	if err := os.Chmod(path, os.FileMode(mode)); err != nil {
+		listener.Close()
+
+		return nil, err
+	}
+
+	return listener, nil
+}
+
+// Start serving the OUI metrics over HTTP
+func startExporter(addr string) error {
+	listener, err := listenExporter(addr)
+	if err != nil {
+		return fmt.Errorf("error listening for exporter: %w", err)
+	}
+
+	slog.Info("Serving metrics", "address", listener.Addr().String())
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", serveMetrics)
+
+	server := &http.Server{
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	go func() {
+		if err := server.Serve(listener); err != nil {
+			slog.Error("Error serving metrics", "error", err.Error())
+		}
+	}()
+
+	return nil
+}
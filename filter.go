@@ -0,0 +1,50 @@
+package main
+
+import (
+	"regexp"
+)
+
+// Check whether the metric file is limited to some OUIs or organizations
+func filtersEnabled() bool {
+	return *includeOrgRegex != "" || *excludeOrgRegex != "" || len(*includeOUIs) > 0
+}
+
+// Reduce the OUI records to the OUIs and organizations selected by the
+// filters. A record is kept if it matches any include filter, or if there are
+// none, unless its organization matches the exclude filter.
+func filterOrganizations(records []ouiRecord) []ouiRecord {
+	if !filtersEnabled() {
+		return records
+	}
+
+	var include, exclude *regexp.Regexp
+	if *includeOrgRegex != "" {
+		include = regexp.MustCompile(*includeOrgRegex)
+	}
+
+	if *excludeOrgRegex != "" {
+		exclude = regexp.MustCompile(*excludeOrgRegex)
+	}
+
+	ouis := map[string]bool{}
+	for _, s := range *includeOUIs {
+		if oui, ok := normalizeOUI(s); ok {
+			ouis[oui] = true
+		}
+	}
+
+	filtered := []ouiRecord{}
+
+	for _, r := range records {
+		included := include == nil && len(ouis) == 0
+		included = included || ouis[r.oui] || (include != nil && include.MatchString(r.organization))
+
+		if !included || (exclude != nil && exclude.MatchString(r.organization)) {
+			continue
+		}
+
+		filtered = append(filtered, r)
+	}
+
+	return filtered
+}
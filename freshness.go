@@ -0,0 +1,51 @@
+package main
+
+var (
+	// SHA-256 of the OUI database downloaded on the previous refresh
+	previousSourceSHA256 string
+
+	// Number of consecutive refreshes which downloaded an unchanged OUI
+	// database
+	unchangedFetches int
+)
+
+// Build samples showing whether the downloaded OUI database changed since the
+// previous refresh. The counts are only advanced once the refresh is
+// published.
+func freshnessSamples(dl download) []sample {
+	if dl.sha256 == "" {
+		return nil
+	}
+
+	changed, unchanged := 1.0, 0
+	if dl.sha256 == previousSourceSHA256 {
+		changed, unchanged = 0, unchangedFetches+1
+	}
+
+	return []sample{
+		{
+			name:  metricNameWithSuffix("source_changed"),
+			value: changed,
+		},
+		{
+			name:  metricNameWithSuffix("source_unchanged_fetches"),
+			value: float64(unchanged),
+		},
+	}
+}
+
+// Record the OUI database downloaded by a published refresh, so that the
+// next download is compared with it
+func recordFreshness(dl download) {
+	if dl.sha256 == "" {
+		return
+	}
+
+	if dl.sha256 == previousSourceSHA256 {
+		unchangedFetches++
+	} else {
+		unchangedFetches = 0
+	}
+
+	previousSourceSHA256 = dl.sha256
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// The patterns of the labels identifying an info series: the OUI label as it
+// is written to the metric file, the registry and prefix of blocks of the other registries,
+// which can share an OUI, and the index of duplicate records
+func seriesKeyPatterns(names infoSeriesNames) []*regexp.Regexp {
+	patterns := []*regexp.Regexp{}
+
+	for _, name := range []string{names.oui, "registry", "prefix", "index"} {
+		patterns = append(patterns, regexp.MustCompile(`[{,]`+regexp.QuoteMeta(name)+`="([^"]*)"`))
+	}
+
+	return patterns
+}
+
+// Find the key of an info series line, which stays the same when only its
+// organization changes
+func seriesKey(patterns []*regexp.Regexp, line string) (string, bool) {
+	values := make([]string, len(patterns))
+
+	for i, pattern := range patterns {
+		if match := pattern.FindStringSubmatch(line); match != nil {
+			values[i] = match[1]
+		}
+	}
+
+	// Every info series has an OUI
+	if values[0] == "" {
+		return "", false
+	}
+
+	return strings.Join(values, "/"), true
+}
+
+// Check whether a line of a metric file is an info series
+func isInfoLine(names infoSeriesNames, line string) bool {
+	return strings.HasPrefix(line, names.metric+"{") || strings.HasPrefix(line, `{"`+escapeQuoted(names.metric)+`"`)
+}
+
+// Read the info series of a metric file, keyed by their OUI
+func readSeries(filename string, names infoSeriesNames) (map[string]string, error) {
+	series := map[string]string{}
+
+	f, err := os.Open(filename)
+	if errors.Is(err, fs.ErrNotExist) {
+		return series, nil
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("error opening previous metric file: %w", err)
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	patterns := seriesKeyPatterns(names)
+
+	for scanner.Scan() {
+		line := scanner.Text()
+
+		if !isInfoLine(names, line) {
+			continue
+		}
+
+		if key, ok := seriesKey(patterns, line); ok {
+			series[key] = line
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("error reading previous metric file: %w", err)
+	}
+
+	return series, nil
+}
+
+// Compare the info samples about to be written with the previous generation
+// of the metric file, counting the added, removed and changed series
+func goldenDiffSamples(metricFile string, samples []sample) ([]sample, error) {
+	// A named pipe, a metric file which is only served by the exporter or an
+	// output file in another format has no previous generation to compare with
+	if metricFile == "" || isFIFO(metricFile) || *outputFormat != "prom" {
+		return nil, nil
+	}
+
+	names, err := infoNames()
+	if err != nil {
+		return nil, err
+	}
+
+	previous, err := readSeries(metricFile, names)
+	if err != nil {
+		return nil, err
+	}
+
+	added, removed, changed := 0, 0, 0
+	current := map[string]bool{}
+	static := staticLabels()
+	patterns := seriesKeyPatterns(names)
+
+	for _, s := range samples {
+		// Compare the lines as they will be written
+		s.labels = sampleOutputLabels(s, static)
+		line := s.String()
+
+		key, ok := seriesKey(patterns, line)
+		if !ok {
+			continue
+		}
+
+		current[key] = true
+
+		old, exists := previous[key]
+		switch {
+		case !exists:
+			added++
+		case old != line:
+			changed++
+		}
+	}
+
+	for key := range previous {
+		if !current[key] {
+			removed++
+		}
+	}
+
+	return []sample{
+		{
+			name:   metricNameWithSuffix("output_series_changes"),
+			labels: []label{{name: "change", value: "added"}},
+			value:  float64(added),
+		},
+		{
+			name:   metricNameWithSuffix("output_series_changes"),
+			labels: []label{{name: "change", value: "removed"}},
+			value:  float64(removed),
+		},
+		{
+			name:   metricNameWithSuffix("output_series_changes"),
+			labels: []label{{name: "change", value: "changed"}},
+			value:  float64(changed),
+		},
+		{
+			name:  metricNameWithSuffix("output_series_previous"),
+			value: float64(len(previous)),
+		},
+	}, nil
+}
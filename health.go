@@ -0,0 +1,263 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"maps"
+	"os"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Health of the collector across refreshes
+type collectorHealth struct {
+	failures            int
+	classFailures       map[string]int
+	consecutiveFailures int
+	lastErrorClass      string
+	lastSuccess         time.Time
+	lastFailure         time.Time
+	lastFetch           fetchDetails
+}
+
+// Record a failed refresh
+func (h *collectorHealth) failure(class string) {
+	h.failures++
+
+	if h.classFailures == nil {
+		h.classFailures = map[string]int{}
+	}
+
+	h.classFailures[class]++
+	h.consecutiveFailures++
+	h.lastErrorClass = class
+	h.lastFailure = time.Now()
+}
+
+// Record a successful refresh
+func (h *collectorHealth) success() {
+	h.consecutiveFailures = 0
+	h.lastSuccess = time.Now()
+}
+
+// Build the samples describing the health of the collector
+func (h collectorHealth) samples() []sample {
+	timestamp := func(t time.Time) float64 {
+		if t.IsZero() {
+			return 0
+		}
+
+		return float64(t.Unix())
+	}
+
+	samples := []sample{
+		{
+			name:  metricNameWithSuffix("collector_failures_total"),
+			value: float64(h.failures),
+		},
+		{
+			name:  metricNameWithSuffix("collector_consecutive_failures"),
+			value: float64(h.consecutiveFailures),
+		},
+		{
+			name:  metricNameWithSuffix("collector_last_success_timestamp_seconds"),
+			value: timestamp(h.lastSuccess),
+		},
+		{
+			name:  metricNameWithSuffix("collector_last_failure_timestamp_seconds"),
+			value: timestamp(h.lastFailure),
+		},
+	}
+
+	for _, class := range slices.Sorted(maps.Keys(h.classFailures)) {
+		samples = append(samples, sample{
+			name:   metricNameWithSuffix("collector_errors_total"),
+			labels: []label{{name: "class", value: class}},
+			value:  float64(h.classFailures[class]),
+		})
+	}
+
+	if h.lastErrorClass != "" {
+		samples = append(samples, sample{
+			name:   metricNameWithSuffix("collector_last_error_info"),
+			labels: []label{{name: "class", value: h.lastErrorClass}},
+			value:  1,
+		})
+	}
+
+	if h.lastFetch != (fetchDetails{}) {
+		samples = append(samples, h.lastFetch.samples()...)
+	}
+
+	samples = append(samples, extraOutputSamples()...)
+
+	return samples
+}
+
+// Update the health metric file, if enabled
+func reportHealth(h collectorHealth) {
+	if *healthFile == "" {
+		return
+	}
+
+	if err := writeMetrics(*healthFile, h.samples()); err != nil {
+		slog.Error("Error writing health metric file", "error", err.Error())
+	}
+}
+
+// Build the samples describing the updates of the metric file, written along
+// with the OUIs so that a stale or failing collector can be detected from the
+// metric file alone. The number of OUIs is written with the OUIs themselves,
+// see entriesSample.
+func updateSamples(lastSuccess time.Time, failures int, duration time.Duration) []sample {
+	timestamp := 0.0
+	if !lastSuccess.IsZero() {
+		timestamp = float64(lastSuccess.Unix())
+	}
+
+	samples := []sample{
+		{
+			name:  metricNameWithSuffix("last_successful_update_timestamp_seconds"),
+			value: timestamp,
+		},
+		{
+			name:  metricNameWithSuffix("update_failures_total"),
+			value: float64(failures),
+		},
+		{
+			name:  metricNameWithSuffix("parse_errors_total"),
+			value: float64(parseErrors),
+		},
+	}
+
+	if duration > 0 {
+		samples = append(samples, sample{
+			name:  metricNameWithSuffix("download_duration_seconds"),
+			value: duration.Seconds(),
+		})
+	}
+
+	return append(samples, registrySamples()...)
+}
+
+// Update the samples describing the updates in the metric file after a
+// refresh which didn't regenerate it, because it failed or found the OUI
+// database unchanged. If the OUI database is loaded, the metric file and its
+// copies are written again, otherwise the samples are replaced in the existing
+// metric file.
+func reportUpdate(ctx context.Context, metricFile string, samples []sample) error {
+	names := map[string]bool{}
+	for _, s := range samples {
+		names[s.name] = true
+	}
+
+	extraSamples = append(slices.DeleteFunc(extraSamples, func(s sample) bool { return names[s.name] }), samples...)
+
+	if ouiRecords == nil {
+		return patchMetricFile(metricFile, samples)
+	}
+
+	seen, err := filterSeen(ouiRecords)
+	if err != nil {
+		return err
+	}
+
+	return publishMetrics(ctx, metricFile, append(recordSamples(seen), extraSamples...))
+}
+
+// Count a failed refresh in the metric file, which keeps its OUIs
+func reportFailedUpdate(ctx context.Context, h collectorHealth) {
+	if err := reportUpdate(ctx, *metricFile, updateSamples(h.lastSuccess, h.failures, 0)); err != nil {
+		slog.Error("Error updating OUI metric file", "error", err.Error())
+	}
+}
+
+// Find the name of the metric of a sample line, or of a HELP or TYPE line
+func lineMetricName(line string) string {
+	if rest, ok := strings.CutPrefix(line, "# "); ok {
+		fields := strings.SplitN(rest, " ", 3)
+		if len(fields) < 2 || (fields[0] != "HELP" && fields[0] != "TYPE") {
+			return ""
+		}
+
+		return fields[1]
+	}
+
+	if strings.HasPrefix(line, `{"`) {
+		name, _, _ := strings.Cut(line[1:], ",")
+		name, _, _ = strings.Cut(name, "}")
+
+		return name
+	}
+
+	name, _, _ := strings.Cut(line, "{")
+	name, _, _ = strings.Cut(name, " ")
+
+	return name
+}
+
+// Replace the samples of some metrics in the metric file, keeping its other
+// lines, or write a metric file of only these samples if there is none yet
+func patchMetricFile(metricFile string, samples []sample) error {
+	if metricFile == "" || *outputFormat != "prom" || isFIFO(metricFile) {
+		return nil
+	}
+
+	content, err := os.ReadFile(metricFile)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return withClass("write", fmt.Errorf("error reading metric file: %w", err))
+	}
+
+	// The names as they appear in sample lines and in HELP and TYPE lines
+	names := map[string]bool{}
+	for _, s := range samples {
+		names[s.name] = true
+		names[`"`+escapeQuoted(s.name)+`"`] = true
+		names[metadataName(familyName(s.name))] = true
+	}
+
+	return writeFileAtomic(metricFile, func(w *bufio.Writer) error {
+		for _, line := range strings.Split(strings.TrimSuffix(string(content), "\n"), "\n") {
+			if line == "" || line == "# EOF" || names[lineMetricName(line)] {
+				continue
+			}
+
+			if _, err := w.WriteString(line + "\n"); err != nil {
+				return err
+			}
+		}
+
+		if _, err := writeSamples(w, samples); err != nil {
+			return err
+		}
+
+		_, err := writeEOF(w)
+
+		return err
+	})
+}
+
+// Read the time of the last successful update from the metric file, which
+// is zero if it can't be read
+func readLastSuccess(metricFile string) time.Time {
+	if metricFile == "" || *outputFormat != "prom" || isFIFO(metricFile) {
+		return time.Time{}
+	}
+
+	families, err := parseMetricFile(metricFile)
+	if err != nil {
+		return time.Time{}
+	}
+
+	metrics := families[metricNameWithSuffix("last_successful_update_timestamp_seconds")].GetMetric()
+	if len(metrics) == 0 || metrics[0].GetGauge().GetValue() == 0 {
+		return time.Time{}
+	}
+
+	return time.Unix(int64(metrics[0].GetGauge().GetValue()), 0)
+}
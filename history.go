@@ -0,0 +1,127 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"maps"
+	"os"
+	"slices"
+	"time"
+)
+
+// History of the OUIs written to the metric file on the most recent refresh,
+// or nil if it isn't tracked
+var ouiHistories map[string]ouiHistory
+
+// When an OUI first appeared in the OUI database and when its organization
+// last changed
+type ouiHistory struct {
+	FirstSeen           time.Time `json:"first_seen"`
+	OrganizationChanged time.Time `json:"organization_changed"`
+	Organization        string    `json:"organization"`
+}
+
+// Read the OUI history state file, which doesn't exist before the first
+// refresh
+func readHistory(filename string) (map[string]ouiHistory, error) {
+	history := map[string]ouiHistory{}
+
+	content, err := os.ReadFile(filename)
+	if errors.Is(err, fs.ErrNotExist) {
+		return history, nil
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("error reading OUI history file: %w", err)
+	}
+
+	if err := json.Unmarshal(content, &history); err != nil {
+		return nil, fmt.Errorf("error parsing OUI history file: %w", err)
+	}
+
+	return history, nil
+}
+
+// Record new OUIs and changed organizations in the OUI history. OUIs which
+// disappear are kept, so that they keep their history if they return.
+func updateHistory(history map[string]ouiHistory, ouiMap map[string]string, now time.Time) {
+	for oui, organization := range ouiMap {
+		h, ok := history[oui]
+
+		switch {
+		case !ok:
+			h = ouiHistory{FirstSeen: now, OrganizationChanged: now, Organization: organization}
+		case h.Organization != organization:
+			h.OrganizationChanged = now
+			h.Organization = organization
+		}
+
+		history[oui] = h
+	}
+}
+
+// Read the OUI history state file and record the current OUIs in it. The
+// updated history is only written back once the refresh is published.
+func loadHistory(filename string, ouiMap map[string]string) (map[string]ouiHistory, error) {
+	history, err := readHistory(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	updateHistory(history, ouiMap, time.Now())
+
+	return history, nil
+}
+
+// Write the OUI history state file
+func writeHistory(filename string, history map[string]ouiHistory) error {
+	err := writeFileAtomic(filename, func(w *bufio.Writer) error {
+		return json.NewEncoder(w).Encode(history)
+	})
+	if err != nil {
+		return fmt.Errorf("error writing OUI history file: %w", err)
+	}
+
+	return nil
+}
+
+// Build samples showing when each OUI of the records written to the metric
+// file first appeared and when its organization last changed
+func historySamples(history map[string]ouiHistory, records []ouiRecord) []sample {
+	if history == nil {
+		return nil
+	}
+
+	keys := map[string]bool{}
+	for _, r := range records {
+		keys[r.key()] = true
+	}
+
+	ouis := slices.Sorted(maps.Keys(keys))
+	samples := make([]sample, 0, 2*len(ouis))
+
+	for _, oui := range ouis {
+		samples = append(samples, sample{
+			name:        metricNameWithSuffix("first_seen_timestamp_seconds"),
+			labels:      []label{{name: *ouiLabel, value: oui}},
+			value:       float64(history[oui].FirstSeen.Unix()),
+			outputNames: true,
+			perOUI:      true,
+		})
+	}
+
+	for _, oui := range ouis {
+		samples = append(samples, sample{
+			name:        metricNameWithSuffix("organization_changed_timestamp_seconds"),
+			labels:      []label{{name: *ouiLabel, value: oui}},
+			value:       float64(history[oui].OrganizationChanged.Unix()),
+			outputNames: true,
+			perOUI:      true,
+		})
+	}
+
+	return samples
+}
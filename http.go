@@ -0,0 +1,263 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptrace"
+	neturl "net/url"
+	"os"
+	"sync"
+	"time"
+)
+
+// An http.RoundTripper which limits the number of concurrent requests. A
+// request holds its slot until its response body is closed, so that the limit
+// covers downloading the body as well.
+type limitedTransport struct {
+	transport http.RoundTripper
+	slots     chan struct{}
+}
+
+func (t *limitedTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	select {
+	case t.slots <- struct{}{}:
+	case <-req.Context().Done():
+		return nil, req.Context().Err()
+	}
+
+	release := sync.OnceFunc(func() { <-t.slots })
+
+	resp, err := t.transport.RoundTrip(req)
+	if err != nil {
+		release()
+
+		return nil, err
+	}
+
+	resp.Body = &slotBody{ReadCloser: resp.Body, release: release}
+
+	return resp, nil
+}
+
+// A response body which frees the slot of its request when it is closed
+type slotBody struct {
+	io.ReadCloser
+	release func()
+}
+
+func (b *slotBody) Close() error {
+	defer b.release()
+
+	return b.ReadCloser.Close()
+}
+
+// Find the proxy of a request: --http-proxy if set, otherwise the proxy
+// configured by the environment
+func proxy() func(*http.Request) (*neturl.URL, error) {
+	if *httpProxy == "" {
+		return http.ProxyFromEnvironment
+	}
+
+	// The URL was checked by validateFlags
+	u, _ := neturl.Parse(*httpProxy)
+
+	return http.ProxyURL(u)
+}
+
+// Build the TLS configuration of the HTTP client from the flags
+func newTLSConfig() (*tls.Config, error) {
+	config := &tls.Config{}
+
+	if *tlsCAFile != "" {
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+
+		pem, err := os.ReadFile(*tlsCAFile)
+		if err != nil {
+			return nil, fmt.Errorf("error reading TLS CA file: %w", err)
+		}
+
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("error reading TLS CA file: no PEM certificates found in %s", *tlsCAFile)
+		}
+
+		config.RootCAs = pool
+	}
+
+	if *tlsCertFile != "" {
+		cert, err := tls.LoadX509KeyPair(*tlsCertFile, *tlsKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("error loading TLS client certificate: %w", err)
+		}
+
+		config.Certificates = []tls.Certificate{cert}
+	}
+
+	return config, nil
+}
+
+// Create the transport shared by all HTTP clients, so that connections are
+// reused between refreshes
+func newTransport(maxConcurrency int) (*http.Transport, error) {
+	tlsConfig, err := newTLSConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	return &http.Transport{
+		Proxy:           proxy(),
+		TLSClientConfig: tlsConfig,
+		DialContext: (&net.Dialer{
+			Timeout:   10 * time.Second,
+			KeepAlive: 30 * time.Second,
+		}).DialContext,
+		ForceAttemptHTTP2:     true,
+		MaxIdleConns:          10,
+		MaxIdleConnsPerHost:   maxConcurrency,
+		IdleConnTimeout:       90 * time.Second,
+		TLSHandshakeTimeout:   10 * time.Second,
+		ExpectContinueTimeout: 1 * time.Second,
+	}, nil
+}
+
+// Create an HTTP client on a transport, recording or replaying its responses
+// if enabled and limiting its concurrent requests
+func newHTTPClient(transport http.RoundTripper, maxConcurrency int) *http.Client {
+	switch {
+	case *replayDir != "":
+		transport = &replayTransport{transport: transport, dir: *replayDir}
+	case *recordDir != "":
+		transport = &recordingTransport{transport: transport, dir: *recordDir}
+	}
+
+	return &http.Client{
+		Timeout:       *httpTimeout,
+		CheckRedirect: checkRedirect,
+		Transport: &limitedTransport{
+			transport: transport,
+			slots:     make(chan struct{}, maxConcurrency),
+		},
+	}
+}
+
+// Create the HTTP clients shared by all requests: one for downloads and one
+// for wireless controllers, which skips certificate verification if enabled
+// as controllers often use self signed certificates
+func setupHTTPClients() error {
+	transport, err := newTransport(*httpConcurrency)
+	if err != nil {
+		return err
+	}
+
+	controllerTransport := transport
+	if *controllerInsecure {
+		controllerTransport = transport.Clone()
+		controllerTransport.TLSClientConfig.InsecureSkipVerify = true //nolint:gosec
+	}
+
+	httpClient = newHTTPClient(transport, *httpConcurrency)
+	controllerClient = newHTTPClient(controllerTransport, *httpConcurrency)
+
+	return nil
+}
+
+// Details of the HTTP request which downloaded the OUI database
+type fetchDetails struct {
+	statusCode    int
+	responseBytes int64
+	redirects     int
+	dns           time.Duration
+	connect       time.Duration
+	tlsHandshake  time.Duration
+}
+
+// Build a trace which records the timings of a request and its redirects.
+// Reused connections add no DNS, connect or TLS handshake time.
+func (f *fetchDetails) trace() *httptrace.ClientTrace {
+	var dnsStart, connectStart, tlsStart time.Time
+
+	return &httptrace.ClientTrace{
+		DNSStart: func(httptrace.DNSStartInfo) {
+			dnsStart = time.Now()
+		},
+		DNSDone: func(httptrace.DNSDoneInfo) {
+			f.dns += time.Since(dnsStart)
+		},
+		ConnectStart: func(string, string) {
+			connectStart = time.Now()
+		},
+		ConnectDone: func(_ string, _ string, err error) {
+			if err == nil {
+				f.connect += time.Since(connectStart)
+			}
+		},
+		TLSHandshakeStart: func() {
+			tlsStart = time.Now()
+		},
+		TLSHandshakeDone: func(_ tls.ConnectionState, err error) {
+			if err == nil {
+				f.tlsHandshake += time.Since(tlsStart)
+			}
+		},
+	}
+}
+
+// Apply the redirect policy configured by the flags
+func checkRedirect(req *http.Request, via []*http.Request) error {
+	if len(via) > *maxRedirects {
+		return withClass("redirect", fmt.Errorf("stopped after %d redirects", *maxRedirects))
+	}
+
+	if *sameHostRedirects && req.URL.Host != via[0].URL.Host {
+		return withClass("redirect", fmt.Errorf("refusing redirect from %s to host %s", via[0].URL.Host, req.URL.Host))
+	}
+
+	return nil
+}
+
+// Count the redirects which were followed to get a response
+func countRedirects(resp *http.Response) int {
+	redirects := 0
+
+	for r := resp.Request; r != nil && r.Response != nil; r = r.Response.Request {
+		redirects++
+	}
+
+	return redirects
+}
+
+// Build the samples describing an HTTP request
+func (f fetchDetails) samples() []sample {
+	return []sample{
+		{
+			name:  metricNameWithSuffix("collector_http_status_code"),
+			value: float64(f.statusCode),
+		},
+		{
+			name:  metricNameWithSuffix("collector_http_response_bytes"),
+			value: float64(f.responseBytes),
+		},
+		{
+			name:  metricNameWithSuffix("collector_http_redirects"),
+			value: float64(f.redirects),
+		},
+		{
+			name:  metricNameWithSuffix("collector_http_dns_seconds"),
+			value: f.dns.Seconds(),
+		},
+		{
+			name:  metricNameWithSuffix("collector_http_connect_seconds"),
+			value: f.connect.Seconds(),
+		},
+		{
+			name:  metricNameWithSuffix("collector_http_tls_handshake_seconds"),
+			value: f.tlsHandshake.Seconds(),
+		},
+	}
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"log/slog"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Split a line of an ids file into its 4 digit hex ID and name
+func splitID(line string) (string, string, bool) {
+	id, name, found := strings.Cut(line, " ")
+	if !found || len(id) != 4 {
+		return "", "", false
+	}
+
+	if _, err := strconv.ParseUint(id, 16, 16); err != nil {
+		return "", "", false
+	}
+
+	return strings.ToLower(id), strings.TrimSpace(name), true
+}
+
+// Parse a file in the format of usb.ids and pci.ids, where each vendor line is
+// followed by its product lines indented with one tab. Product samples are
+// only built if productMetric is set.
+func parseIDs(filename string, logger *slog.Logger, vendorMetric string, productMetric string) ([]sample, error) {
+	input, err := os.Open(filename)
+	if err != nil {
+		return nil, fmt.Errorf("error opening ids file: %w", err)
+	}
+	defer input.Close()
+
+	samples := []sample{}
+	products := []sample{}
+	vendorID, vendorName := "", ""
+
+	scanner := bufio.NewScanner(input)
+
+	for scanner.Scan() {
+		line := scanner.Text()
+
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		if !strings.HasPrefix(line, "\t") {
+			var ok bool
+
+			// Lines which aren't vendors start other sections, e.g. the
+			// device classes
+			vendorID, vendorName, ok = splitID(line)
+			if !ok {
+				vendorID = ""
+
+				continue
+			}
+
+			samples = append(samples, sample{
+				name: vendorMetric,
+				labels: []label{
+					{name: "vendor_id", value: vendorID},
+					{name: "vendor_name", value: vendorName},
+				},
+				value: 1,
+			})
+
+			continue
+		}
+
+		if productMetric == "" || vendorID == "" || strings.HasPrefix(line, "\t\t") {
+			continue
+		}
+
+		productID, productName, ok := splitID(line[1:])
+		if !ok {
+			logger.Debug("Ignoring malformed product line in ids file", "line", line)
+
+			continue
+		}
+
+		products = append(products, sample{
+			name: productMetric,
+			labels: []label{
+				{name: "vendor_id", value: vendorID},
+				{name: "vendor_name", value: vendorName},
+				{name: "product_id", value: productID},
+				{name: "product_name", value: productName},
+			},
+			value: 1,
+		})
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, withClass("parse", fmt.Errorf("error reading ids file: %w", err))
+	}
+
+	// Keep the samples of each metric together
+	return append(samples, products...), nil
+}
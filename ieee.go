@@ -0,0 +1,228 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/adaricorp/oui-textfile-collector/pkg/ouidb"
+)
+
+// An IEEE registry of MAC address block assignments
+type ieeeRegistry struct {
+	name string
+	url  string
+	// Number of hex digits of an assignment
+	digits int
+}
+
+// The IEEE registries which can be downloaded, all published as CSV files in
+// the same format
+var ieeeRegistries = []ieeeRegistry{
+	{name: "mal", url: url, digits: 6},
+	{name: "mam", url: ouidb.MAMURL, digits: 7},
+	{name: "mas", url: ouidb.MASURL, digits: 9},
+	{name: "iab", url: ouidb.IABURL, digits: 9},
+	{name: "cid", url: ouidb.CIDURL, digits: 6},
+}
+
+// A downloaded IEEE registry other than MA-L
+type registryDownload struct {
+	registry  ieeeRegistry
+	filename  string
+	validator cacheValidator
+	// The server answered 304 Not Modified, so nothing was downloaded
+	notModified bool
+}
+
+// Outcome of the refreshes of an IEEE registry since startup
+type registryStatus struct {
+	lastSuccess time.Time
+	failures    int
+}
+
+var (
+	// Outcomes of the refreshes of the selected registries, by name
+	registryStatuses = map[string]registryStatus{}
+
+	// Records of the selected registries other than MA-L as last parsed, so
+	// that a registry which fails to refresh keeps its previous records
+	registryRecords = map[string][]ouiRecord{}
+)
+
+// Count a successful refresh of a registry
+func registrySucceeded(name string) {
+	status := registryStatuses[name]
+	status.lastSuccess = time.Now()
+	registryStatuses[name] = status
+}
+
+// Count the registries of a download which found them all unchanged as
+// successfully refreshed
+func registriesUnchanged(dl download) {
+	registrySucceeded("mal")
+
+	for _, r := range dl.registries {
+		registrySucceeded(r.registry.name)
+	}
+}
+
+// Count a failed refresh of a registry
+func registryFailed(name string) {
+	status := registryStatuses[name]
+	status.failures++
+	registryStatuses[name] = status
+}
+
+// Build the samples describing the refreshes of each selected registry, if
+// registries other than MA-L are selected
+func registrySamples() []sample {
+	extra := extraRegistries()
+	if len(extra) == 0 {
+		return nil
+	}
+
+	samples := []sample{}
+
+	for _, r := range append([]ieeeRegistry{ieeeRegistries[0]}, extra...) {
+		status := registryStatuses[r.name]
+		labels := []label{{name: "registry", value: r.name}}
+
+		timestamp := 0.0
+		if !status.lastSuccess.IsZero() {
+			timestamp = float64(status.lastSuccess.Unix())
+		}
+
+		samples = append(samples,
+			sample{
+				name:   metricNameWithSuffix("registry_last_success_timestamp_seconds"),
+				labels: labels,
+				value:  timestamp,
+			},
+			sample{
+				name:   metricNameWithSuffix("registry_failures_total"),
+				labels: labels,
+				value:  float64(status.failures),
+			},
+		)
+	}
+
+	return samples
+}
+
+// Names of the registries selected by the flags
+func registryNames() []string {
+	return strings.Split(*registries, ",")
+}
+
+// Check the registries selected by the flags
+func validateRegistries() error {
+	names := registryNames()
+
+	for _, name := range names {
+		if !slices.ContainsFunc(ieeeRegistries, func(r ieeeRegistry) bool { return r.name == name }) {
+			return fmt.Errorf("invalid --registries %q: unknown registry %q", *registries, name)
+		}
+	}
+
+	if !slices.Contains(names, "mal") {
+		return fmt.Errorf("invalid --registries %q: must include mal", *registries)
+	}
+
+	if *ouiSource == "wireshark" && len(names) > 1 {
+		return fmt.Errorf("invalid --registries %q: the Wireshark manuf file already merges the MA-M and MA-S registries, only mal can be used with --source wireshark", *registries)
+	}
+
+	return nil
+}
+
+// The selected registries other than MA-L
+func extraRegistries() []ieeeRegistry {
+	names := registryNames()
+
+	return slices.DeleteFunc(slices.Clone(ieeeRegistries[1:]), func(r ieeeRegistry) bool {
+		return !slices.Contains(names, r.name)
+	})
+}
+
+// Download the selected registries other than MA-L. A registry which fails
+// to download is left out, and keeps its previous records, unless the
+// context is cancelled.
+func fetchExtraRegistries(ctx context.Context) ([]registryDownload, error) {
+	downloads := []registryDownload{}
+
+	for _, r := range extraRegistries() {
+		dl, err := fetch(ctx, r.url, r.name+".csv")
+		if err != nil {
+			os.Remove(dl.filename)
+
+			if ctx.Err() != nil {
+				removeRegistryDownloads(downloads)
+
+				return nil, fmt.Errorf("error downloading %s registry: %w", r.name, err)
+			}
+
+			registryFailed(r.name)
+			slog.Warn("Error downloading registry, keeping its previous records", "registry", r.name, "error", err.Error())
+
+			continue
+		}
+
+		downloads = append(downloads, registryDownload{
+			registry:    r,
+			filename:    dl.filename,
+			validator:   dl.validator,
+			notModified: dl.notModified,
+		})
+	}
+
+	return downloads, nil
+}
+
+// Parse the selected registries other than MA-L of a download. A registry
+// which failed to download or parse keeps the records it had on its last
+// successful refresh, if any.
+func parseExtraRegistries(dl download) []ouiRecord {
+	records := []ouiRecord{}
+
+	for _, r := range extraRegistries() {
+		i := slices.IndexFunc(dl.registries, func(d registryDownload) bool { return d.registry.name == r.name })
+		if i == -1 {
+			records = append(records, registryRecords[r.name]...)
+
+			continue
+		}
+
+		more, err := parse(dl.registries[i].filename, r)
+		if err != nil {
+			registryFailed(r.name)
+			slog.Warn("Error parsing registry, keeping its previous records", "registry", r.name, "error", err.Error())
+
+			// Download the registry in full on the next refresh
+			dl.registries[i].validator = cacheValidator{}
+
+			records = append(records, registryRecords[r.name]...)
+
+			continue
+		}
+
+		registrySucceeded(r.name)
+		registryRecords[r.name] = more
+		records = append(records, more...)
+	}
+
+	return records
+}
+
+// Remove the temporary files of downloaded registries
+func removeRegistryDownloads(downloads []registryDownload) {
+	for _, d := range downloads {
+		if d.filename != "" {
+			os.Remove(d.filename)
+		}
+	}
+}
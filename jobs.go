@@ -0,0 +1,313 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"maps"
+	"os"
+	"os/exec"
+	"os/signal"
+	"slices"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/pelletier/go-toml/v2"
+	"github.com/peterbourgon/ff/v4/fftoml"
+)
+
+// A job of the config file, which runs a collector of its own with its
+// settings applied over the top-level settings of the config file
+type configJob struct {
+	name     string
+	settings map[string]any
+}
+
+// The jobs of the config file which was last parsed
+var configJobs []configJob
+
+// Settings which can't be set by a job
+var jobExcludedFlags = []string{"config", "job"}
+
+// Read the jobs of a config file from its jobs array of tables
+func decodeJobs(value any) ([]configJob, error) {
+	if value == nil {
+		return nil, nil
+	}
+
+	tables, ok := value.([]any)
+	if !ok {
+		return nil, errors.New("jobs must be an array of tables")
+	}
+
+	jobs := []configJob{}
+
+	for i, table := range tables {
+		settings, ok := table.(map[string]any)
+		if !ok {
+			return nil, fmt.Errorf("job %d must be a table", i+1)
+		}
+
+		name, _ := settings["name"].(string)
+		if name == "" {
+			return nil, fmt.Errorf("job %d has no name", i+1)
+		}
+
+		settings = maps.Clone(settings)
+		delete(settings, "name")
+
+		jobs = append(jobs, configJob{name: name, settings: settings})
+	}
+
+	return jobs, nil
+}
+
+// Parse a TOML config file. Its jobs are kept aside, and the settings of the
+// job selected by --job replace its top-level settings.
+func parseConfigFile(r io.Reader, set func(name, value string) error) error {
+	config := map[string]any{}
+	if err := toml.NewDecoder(r).Decode(&config); err != nil {
+		return err
+	}
+
+	jobs, err := decodeJobs(config["jobs"])
+	if err != nil {
+		return err
+	}
+
+	delete(config, "jobs")
+	configJobs = jobs
+
+	if *jobName != "" {
+		i := slices.IndexFunc(jobs, func(j configJob) bool { return j.name == *jobName })
+		if i == -1 {
+			return fmt.Errorf("config file has no job named %q", *jobName)
+		}
+
+		maps.Copy(config, jobs[i].settings)
+	}
+
+	content, err := toml.Marshal(config)
+	if err != nil {
+		return err
+	}
+
+	return fftoml.Parse(bytes.NewReader(content), set)
+}
+
+// Check that the jobs of the config file have unique names and only set
+// known flags
+func validateJobs() error {
+	errs := []error{}
+	names := map[string]bool{}
+
+	for _, j := range configJobs {
+		if names[j.name] {
+			errs = append(errs, fmt.Errorf("invalid job %q: name is used by another job", j.name))
+		}
+
+		names[j.name] = true
+
+		for _, key := range slices.Sorted(maps.Keys(j.settings)) {
+			if _, ok := rootCmd.Flags.GetFlag(key); !ok || slices.Contains(jobExcludedFlags, key) {
+				errs = append(errs, fmt.Errorf("invalid job %q: %q can't be set by a job", j.name, key))
+			}
+		}
+	}
+
+	if *jobName != "" && *configFile == "" {
+		errs = append(errs, errors.New("invalid --job: needs a --config file with jobs"))
+	}
+
+	return errors.Join(errs...)
+}
+
+// Check whether this process runs the jobs of the config file rather than a
+// collector of its own
+func supervisingJobs() bool {
+	return len(configJobs) > 0 && *jobName == ""
+}
+
+// Split joined errors into the errors they hold
+func splitErrors(err error) []error {
+	joined, ok := err.(interface{ Unwrap() []error })
+	if !ok {
+		return []error{err}
+	}
+
+	errs := []error{}
+	for _, e := range joined.Unwrap() {
+		errs = append(errs, splitErrors(e)...)
+	}
+
+	return errs
+}
+
+// Check the flags of every job of the config file, as they are resolved by
+// the process of the job, and optionally the files and directories they
+// use. The flags are parsed again without a job afterwards.
+func validateJobFlags(resources bool) error {
+	errs := []error{}
+
+	for _, j := range configJobs {
+		err := applyJobConfig(configContent, j.name)
+		if err == nil {
+			err = validateFlags()
+
+			if resources {
+				err = errors.Join(err, validateResources())
+			}
+		}
+
+		if err != nil {
+			for _, e := range splitErrors(err) {
+				errs = append(errs, fmt.Errorf("job %q: %w", j.name, e))
+			}
+		}
+	}
+
+	if err := applyConfig(configContent); err != nil {
+		errs = append(errs, err)
+	}
+
+	return errors.Join(errs...)
+}
+
+// The processes running the jobs, which SIGHUP is forwarded to
+type jobProcesses struct {
+	mu        sync.Mutex
+	processes map[string]*os.Process
+}
+
+func (p *jobProcesses) set(name string, process *os.Process) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if process == nil {
+		delete(p.processes, name)
+	} else {
+		p.processes[name] = process
+	}
+}
+
+func (p *jobProcesses) signal(sig os.Signal) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for name, process := range p.processes {
+		if err := process.Signal(sig); err != nil {
+			slog.Error("Error signalling job", "job", name, "error", err.Error())
+		}
+	}
+}
+
+// Run a job in a process of its own, restarting it with backoff when it
+// exits, until the context is cancelled. With --oneshot, the job runs once
+// and its error is returned.
+func superviseJob(ctx context.Context, j configJob, executable string, processes *jobProcesses) error {
+	// The flags and environment variables of the collector apply to every
+	// job, as they take precedence over the config file
+	args := append(slices.Clone(os.Args[1:]), "--job", j.name)
+
+	retries := 0
+
+	for {
+		cmd := exec.CommandContext(ctx, executable, args...)
+		cmd.Stdout = os.Stdout
+		cmd.Stderr = os.Stderr
+
+		// Let the job shut down cleanly, as on SIGTERM to the collector
+		cmd.Cancel = func() error { return cmd.Process.Signal(syscall.SIGTERM) }
+		cmd.WaitDelay = time.Minute
+
+		started := time.Now()
+
+		err := cmd.Start()
+		if err == nil {
+			processes.set(j.name, cmd.Process)
+			err = cmd.Wait()
+			processes.set(j.name, nil)
+		}
+
+		if ctx.Err() != nil {
+			return nil
+		}
+
+		if *oneshot {
+			if err != nil {
+				return fmt.Errorf("job %q failed: %w", j.name, err)
+			}
+
+			return nil
+		}
+
+		// A job which ran for a while is restarted without backing off
+		if time.Since(started) > *backoffMax {
+			retries = 0
+		}
+
+		wait := backoff(retries)
+		retries++
+
+		if err == nil {
+			err = errors.New("exited")
+		}
+
+		slog.Error("Job stopped, restarting it", "job", j.name, "error", err.Error(), "retry", wait)
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(wait):
+		}
+	}
+}
+
+// Run each job of the config file in a process of its own until SIGTERM or
+// SIGINT, forwarding SIGHUP to them. Jobs which are added to or removed from
+// the config file are only picked up on restart.
+func runJobs(ctx context.Context) error {
+	executable, err := os.Executable()
+	if err != nil {
+		return fmt.Errorf("error finding executable to run jobs: %w", err)
+	}
+
+	ctx, stop := signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+
+	processes := &jobProcesses{processes: map[string]*os.Process{}}
+	errs := make([]error, len(configJobs))
+	wg := sync.WaitGroup{}
+
+	for i, j := range configJobs {
+		slog.Info("Starting job", "job", j.name)
+
+		wg.Go(func() {
+			errs[i] = superviseJob(ctx, j, executable, processes)
+		})
+	}
+
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+
+	for {
+		select {
+		case <-done:
+			return errors.Join(errs...)
+		case <-hup:
+			slog.Info("Forwarding SIGHUP to jobs")
+			processes.signal(syscall.SIGHUP)
+		}
+	}
+}
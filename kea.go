@@ -0,0 +1,138 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+)
+
+// Response to a Kea control API command
+type keaResponse struct {
+	Result    int    `json:"result"`
+	Text      string `json:"text"`
+	Arguments struct {
+		Leases []struct {
+			IPAddress string `json:"ip-address"`
+			HWAddress string `json:"hw-address"`
+			Hostname  string `json:"hostname"`
+			State     int    `json:"state"`
+		} `json:"leases"`
+	} `json:"arguments"`
+}
+
+// Send a command to the Kea control agent
+func keaCommand(ctx context.Context, url string, command string, service string) ([]keaResponse, error) {
+	body, err := json.Marshal(map[string]any{
+		"command": command,
+		"service": []string{service},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error encoding Kea command: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(
+		ctx,
+		http.MethodPost,
+		url,
+		bytes.NewReader(body),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("error creating http request: %w", err)
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", userAgent)
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("error doing http request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected http status: %s", resp.Status)
+	}
+
+	responses := []keaResponse{}
+	if err := json.NewDecoder(resp.Body).Decode(&responses); err != nil {
+		return nil, fmt.Errorf("error decoding Kea response: %w", err)
+	}
+
+	return responses, nil
+}
+
+// Read the active DHCPv4 and DHCPv6 leases from the Kea control API. A
+// service whose command fails is skipped, and an error is only returned if the
+// commands for every service failed.
+func readKeaLeases(ctx context.Context, url string) ([]dhcpLease, error) {
+	leases := []dhcpLease{}
+	errs := []error{}
+	commands := []struct{ command, service string }{
+		{"lease4-get-all", "dhcp4"},
+		{"lease6-get-all", "dhcp6"},
+	}
+
+	for _, c := range commands {
+		responses, err := keaCommand(ctx, url, c.command, c.service)
+		if err != nil {
+			slog.Warn(
+				"Error running Kea command, skipping service",
+				"command", c.command,
+				"service", c.service,
+				"error", err.Error(),
+			)
+
+			errs = append(errs, fmt.Errorf("error running Kea command %s: %w", c.command, err))
+
+			continue
+		}
+
+		for _, r := range responses {
+			switch r.Result {
+			case 0:
+			case 2, 3:
+				// Unsupported command or no leases, e.g. the service isn't
+				// running or the lease_cmds hook isn't loaded for it
+				continue
+			default:
+				// Only skip the failing service, e.g. a control agent
+				// without a dhcp6 server answers 1 for it
+				slog.Warn(
+					"Error running Kea command, skipping service",
+					"command", c.command,
+					"service", c.service,
+					"result", r.Result,
+					"error", r.Text,
+				)
+
+				continue
+			}
+
+			for _, l := range r.Arguments.Leases {
+				mac, err := net.ParseMAC(l.HWAddress)
+
+				// Only leases in the default state are active
+				if err != nil || l.State != 0 {
+					continue
+				}
+
+				leases = append(leases, dhcpLease{
+					ip:       l.IPAddress,
+					mac:      mac,
+					hostname: l.Hostname,
+				})
+			}
+		}
+	}
+
+	if len(errs) == len(commands) {
+		return nil, errors.Join(errs...)
+	}
+
+	return leases, nil
+}
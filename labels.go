@@ -0,0 +1,162 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/prometheus/common/model"
+)
+
+// Parse the constant labels given with --label
+func staticLabels() []label {
+	labels := []label{}
+
+	for _, kv := range *labelFlags {
+		name, value, _ := strings.Cut(kv, "=")
+		labels = append(labels, label{name: name, value: value})
+	}
+
+	return labels
+}
+
+// Check the label names and the constant labels given with --label
+func validateLabels() []error {
+	errs := []error{}
+	seen := map[string]bool{}
+
+	scheme := model.LegacyValidation
+	if *nameEscaping == "utf8" {
+		scheme = model.UTF8Validation
+	}
+
+	for _, l := range []struct {
+		flag string
+		name string
+	}{
+		{"oui-label", *ouiLabel},
+		{"org-label", *orgLabel},
+	} {
+		if !scheme.IsValidLabelName(l.name) || strings.HasPrefix(l.name, "__") {
+			errs = append(errs, fmt.Errorf("invalid --%s %q: not a valid label name", l.flag, l.name))
+		}
+	}
+
+	// A label of the OUI series whose name is used twice would silently
+	// replace the other one
+	added := map[string]string{}
+
+	for _, l := range infoLabelNames() {
+		if flag, ok := added[l.name]; ok {
+			errs = append(errs, fmt.Errorf("invalid --%s: label %q is already added by --%s", l.flag, l.name, flag))
+
+			continue
+		}
+
+		added[l.name] = l.flag
+	}
+
+	for _, kv := range *labelFlags {
+		name, _, ok := strings.Cut(kv, "=")
+
+		switch {
+		case !ok:
+			errs = append(errs, fmt.Errorf("invalid --label %q: must be key=value", kv))
+		case !scheme.IsValidLabelName(name) || strings.HasPrefix(name, "__"):
+			errs = append(errs, fmt.Errorf("invalid --label %q: %q is not a valid label name", kv, name))
+		case seen[name]:
+			errs = append(errs, fmt.Errorf("invalid --label %q: label %q is set more than once", kv, name))
+		case added[name] != "":
+			errs = append(errs, fmt.Errorf("invalid --label %q: label %q is already added by --%s", kv, name, added[name]))
+		}
+
+		seen[name] = true
+	}
+
+	return errs
+}
+
+// A label of the OUI series and the flag which adds it
+type infoLabel struct {
+	flag string
+	name string
+}
+
+// The names of the labels of the OUI series, other than the constant labels,
+// as they are written with the flags
+func infoLabelNames() []infoLabel {
+	labels := []infoLabel{
+		{"oui-label", *ouiLabel},
+		{"org-label", *orgLabel},
+	}
+
+	if *organizationHashFile != "" {
+		labels = append(labels, infoLabel{"organization-hash-file", "organization_hash"})
+	}
+
+	if len(extraRegistries()) > 0 {
+		labels = append(labels, infoLabel{"registries", "registry"}, infoLabel{"registries", "prefix"})
+	}
+
+	if *duplicatePolicy == "records" {
+		labels = append(labels, infoLabel{"duplicate-policy", "index"})
+	} else if *mergedLabel {
+		labels = append(labels, infoLabel{"merged-label", "merged"})
+	}
+
+	if *assignmentLabel {
+		labels = append(labels, infoLabel{"assignment-label", "assignment"})
+	}
+
+	return labels
+}
+
+// Add the constant labels to the labels of a sample. A label of the sample
+// itself takes precedence over a constant label with the same name.
+func withStaticLabels(labels []label, static []label) []label {
+	if len(static) == 0 {
+		return labels
+	}
+
+	combined := slices.Clone(labels)
+
+	for _, l := range static {
+		if !slices.ContainsFunc(labels, func(own label) bool { return own.name == l.name }) {
+			combined = append(combined, l)
+		}
+	}
+
+	return combined
+}
+
+// Rename the OUI and organization name labels as configured, and add the
+// constant labels
+func outputLabels(labels []label, static []label) []label {
+	if *ouiLabel != "oui" || *orgLabel != "organization_name" {
+		renamed := make([]label, len(labels))
+
+		for i, l := range labels {
+			switch l.name {
+			case "oui":
+				l.name = *ouiLabel
+			case "organization_name":
+				l.name = *orgLabel
+			}
+
+			renamed[i] = l
+		}
+
+		labels = renamed
+	}
+
+	return withStaticLabels(labels, static)
+}
+
+// Build the labels a sample is written with
+func sampleOutputLabels(s sample, static []label) []label {
+	if s.outputNames {
+		return withStaticLabels(s.labels, static)
+	}
+
+	return outputLabels(s.labels, static)
+}
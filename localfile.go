@@ -0,0 +1,92 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	neturl "net/url"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Find the path of a file:// URL, which names a registry mirrored to the
+// local filesystem
+func localPath(source string) (string, bool) {
+	if !strings.HasPrefix(source, "file:") {
+		return "", false
+	}
+
+	u, err := neturl.Parse(source)
+	if err != nil {
+		return "", false
+	}
+
+	if u.Opaque != "" {
+		return u.Opaque, true
+	}
+
+	return u.Path, true
+}
+
+// Build the file:// URL of --source-file
+func sourceFileURL() string {
+	path, err := filepath.Abs(*sourceFile)
+	if err != nil {
+		path = *sourceFile
+	}
+
+	return (&neturl.URL{Scheme: "file", Path: filepath.ToSlash(path)}).String()
+}
+
+// Copy a registry from the local filesystem to a temporary file whose name
+// starts with pattern. A file which hasn't changed since the last successful
+// refresh is treated like a 304 Not Modified response.
+func fetchFile(source string, path string, pattern string) (download, error) {
+	input, err := os.Open(path)
+	if err != nil {
+		return download{}, withClass("download", fmt.Errorf("error opening source file: %w", err))
+	}
+	defer input.Close()
+
+	info, err := input.Stat()
+	if err != nil {
+		return download{}, withClass("download", fmt.Errorf("error reading source file: %w", err))
+	}
+
+	f, err := os.CreateTemp(*tempDir, pattern)
+	if err != nil {
+		return download{}, fmt.Errorf("error creating temporary file: %w", err)
+	}
+	defer f.Close()
+
+	dl := download{
+		url:          source,
+		filename:     f.Name(),
+		lastModified: info.ModTime(),
+	}
+
+	hash := sha256.New()
+
+	dl.fetch.responseBytes, err = io.Copy(io.MultiWriter(f, hash), input)
+	if err != nil {
+		return dl, fmt.Errorf("error copying source file: %w", err)
+	}
+
+	dl.sha256 = hex.EncodeToString(hash.Sum(nil))
+	dl.validator = cacheValidator{
+		lastModified: info.ModTime().UTC().Format(http.TimeFormat),
+		sha256:       dl.sha256,
+	}
+
+	if cachedValidator(source).sha256 == dl.sha256 {
+		os.Remove(dl.filename)
+
+		dl.filename = ""
+		dl.notModified = true
+	}
+
+	return dl, nil
+}
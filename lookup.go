@@ -0,0 +1,285 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"net"
+	"os"
+	"slices"
+
+	"github.com/adaricorp/oui-textfile-collector/pkg/ouidb"
+	"github.com/peterbourgon/ff/v4"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/expfmt"
+	"github.com/prometheus/common/model"
+)
+
+var (
+	lookupCSVFile *string
+	lookupJSON    *bool
+)
+
+// Result of looking up a MAC address
+type lookupResult struct {
+	MAC          string `json:"mac"`
+	Found        bool   `json:"found"`
+	Organization string `json:"organization_name,omitempty"`
+	Prefix       string `json:"prefix,omitempty"`
+	Registry     string `json:"registry,omitempty"`
+}
+
+// Create the lookup subcommand
+func newLookupCommand(parent *ff.FlagSet) *ff.Command {
+	fs := ff.NewFlagSet("lookup").SetParent(parent)
+	lookupCSVFile = fs.StringLong(
+		"csv-file",
+		"",
+		"Look up MAC addresses in a local copy of an IEEE registry CSV file instead of the output file",
+	)
+	lookupJSON = fs.BoolLong(
+		"json",
+		"Print one JSON object per MAC address",
+	)
+
+	return &ff.Command{
+		Name:      "lookup",
+		Usage:     binName + " lookup [FLAGS] <MAC_ADDRESS> ...",
+		ShortHelp: "Look up the organizations of MAC addresses in the local OUI database",
+		Flags:     fs,
+		Exec:      lookup,
+	}
+}
+
+// Map the label names of a parsed sample to their values
+func labelValues(m *dto.Metric) map[string]string {
+	labels := map[string]string{}
+
+	for _, l := range m.GetLabel() {
+		labels[l.GetName()] = l.GetValue()
+	}
+
+	return labels
+}
+
+// Parse a metric file in the text exposition format
+func parseMetricFile(filename string) (map[string]*dto.MetricFamily, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, fmt.Errorf("error opening metric file: %w", err)
+	}
+	defer f.Close()
+
+	parser := expfmt.NewTextParser(model.UTF8Validation)
+
+	families, err := parser.TextToMetricFamilies(f)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing metric file: %w", err)
+	}
+
+	return families, nil
+}
+
+// Read the OUIs and their organizations back from a parsed metric file, in
+// the order they are written
+func metricOrgs(families map[string]*dto.MetricFamily) ([]ouidb.Org, error) {
+	info, err := infoNames()
+	if err != nil {
+		return nil, err
+	}
+
+	names := map[string]string{}
+	if *organizationHashFile != "" {
+		names, err = readOrganizationHashes(*organizationHashFile)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	orgs := []ouidb.Org{}
+
+	if family, ok := families[info.metric]; ok {
+		for _, m := range family.GetMetric() {
+			labels := labelValues(m)
+
+			org := ouidb.Org{
+				Name:     labels[info.organization],
+				Registry: labels["registry"],
+				Prefix:   labels["prefix"],
+			}
+
+			if org.Prefix == "" {
+				org.Prefix = labels[info.oui]
+			}
+
+			if *organizationHashFile != "" {
+				org.Name = names[org.Name]
+			}
+
+			orgs = append(orgs, org)
+		}
+	}
+
+	return orgs, nil
+}
+
+// Join the organizations of blocks written more than once, one after the
+// other, as with the records duplicate policy
+func joinOrgs(orgs []ouidb.Org) []ouidb.Org {
+	joined := []ouidb.Org{}
+	index := map[string]int{}
+
+	for _, org := range orgs {
+		if i, ok := index[org.Prefix]; ok {
+			joined[i].Name += *joinDelimiter + org.Name
+			continue
+		}
+
+		index[org.Prefix] = len(joined)
+		joined = append(joined, org)
+	}
+
+	return joined
+}
+
+// Read the OUIs and their organizations back from a JSON output file
+func readJSONOrgs(filename string) ([]ouidb.Org, error) {
+	content, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, fmt.Errorf("error reading JSON output file: %w", err)
+	}
+
+	ouiMap := map[string]string{}
+	if err := json.Unmarshal(content, &ouiMap); err != nil {
+		return nil, fmt.Errorf("error parsing JSON output file: %w", err)
+	}
+
+	orgs := make([]ouidb.Org, 0, len(ouiMap))
+	for _, prefix := range slices.Sorted(maps.Keys(ouiMap)) {
+		orgs = append(orgs, ouidb.Org{Name: ouiMap[prefix], Prefix: prefix})
+	}
+
+	return orgs, nil
+}
+
+// Read the OUI database back from the output file, in the format of
+// --output-format
+func readOutputDB(filename string) (*ouidb.DB, error) {
+	var orgs []ouidb.Org
+	var err error
+
+	switch *outputFormat {
+	case "json":
+		orgs, err = readJSONOrgs(filename)
+	case "sqlite":
+		orgs, err = readSQLiteOrgs(filename)
+	default:
+		var families map[string]*dto.MetricFamily
+
+		families, err = parseMetricFile(filename)
+		if err == nil {
+			orgs, err = metricOrgs(families)
+		}
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return ouidb.New(joinOrgs(orgs)...), nil
+}
+
+// Read the mapping from organization hash to name
+func readOrganizationHashes(filename string) (map[string]string, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, fmt.Errorf("error opening organization hash file: %w", err)
+	}
+	defer f.Close()
+
+	parser := expfmt.NewTextParser(model.UTF8Validation)
+
+	families, err := parser.TextToMetricFamilies(f)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing organization hash file: %w", err)
+	}
+
+	names := map[string]string{}
+
+	for _, m := range families[metricNameWithSuffix("organization_hash_info")].GetMetric() {
+		labels := labelValues(m)
+		names[labels["organization_hash"]] = labels[*orgLabel]
+	}
+
+	return names, nil
+}
+
+// Print the organizations of the MAC addresses given as arguments
+func lookup(ctx context.Context, args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("expected at least one MAC address")
+	}
+
+	macs := make([]net.HardwareAddr, 0, len(args))
+	for _, arg := range args {
+		mac, err := net.ParseMAC(arg)
+		if err != nil {
+			return fmt.Errorf("invalid MAC address %q: %w", arg, err)
+		}
+
+		macs = append(macs, mac)
+	}
+
+	var db *ouidb.DB
+	var err error
+
+	if *lookupCSVFile != "" {
+		db, err = ouidb.LoadFiles(*lookupCSVFile)
+	} else {
+		db, err = readOutputDB(*metricFile)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	missing := 0
+	encoder := json.NewEncoder(os.Stdout)
+
+	for _, mac := range macs {
+		org, found := db.Lookup(mac)
+		if !found {
+			missing++
+		}
+
+		if *lookupJSON {
+			result := lookupResult{
+				MAC:          mac.String(),
+				Found:        found,
+				Organization: org.Name,
+				Prefix:       org.Prefix,
+				Registry:     org.Registry,
+			}
+
+			if err := encoder.Encode(result); err != nil {
+				return fmt.Errorf("error writing lookup result: %w", err)
+			}
+
+			continue
+		}
+
+		if !found {
+			fmt.Printf("%s\tunknown\n", mac)
+			continue
+		}
+
+		fmt.Printf("%s\t%s\t%s\n", mac, org.Prefix, org.Name)
+	}
+
+	if missing > 0 {
+		return fmt.Errorf("%d of %d MAC addresses not found", missing, len(macs))
+	}
+
+	return nil
+}
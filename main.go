@@ -2,18 +2,29 @@ package main
 
 import (
 	"context"
+	crand "crypto/rand"
+	"crypto/sha256"
 	"encoding/csv"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"io"
 	"log/slog"
 	"math"
 	"math/rand"
 	"net/http"
+	"net/http/httptrace"
 	"os"
+	"os/signal"
 	"runtime"
+	"slices"
+	"strconv"
 	"strings"
+	"sync"
+	"syscall"
 	"time"
 
+	"github.com/adaricorp/oui-textfile-collector/pkg/ouidb"
 	"github.com/peterbourgon/ff/v4"
 	"github.com/peterbourgon/ff/v4/ffhelp"
 	"github.com/prometheus/common/version"
@@ -21,218 +32,1693 @@ import (
 
 const (
 	binName = "oui_textfile_collector"
-	url     = "https://standards-oui.ieee.org/oui/oui.csv"
+	url     = ouidb.MALURL
 )
 
 var (
-	logLevel  *string
-	slogLevel *slog.LevelVar = new(slog.LevelVar)
+	configFile *string
+	jobName    *string
+	logLevel   *string
+	logFormat  *string
+	logFile    *string
+	slogLevel  *slog.LevelVar = new(slog.LevelVar)
 
 	refreshInterval *string
+	refreshSchedule *string
+	oneshot         *bool
+	tempDir         *string
 	metricFile      *string
+	extraOutputs    *[]string
+	outputFormat    *string
+	sqliteDeltaMax  *int
+	outputMode      *string
+	outputOwner     *string
+	outputGroup     *string
+	mergeDir        *string
+	exporterListen  *string
+	exporterMode    *string
+	registries      *string
+	parseMode       *string
+
+	ouiSource           *string
+	wiresharkURL        *string
+	ouiURLs             *[]string
+	sourceFile          *string
+	wiresharkShortNames *bool
+	pushgatewayURL      *string
+	pushgatewayJob      *string
+	pushgatewayInstance *string
+	pushgatewayMetrics  *string
+
+	historyFile     *string
+	renameLogFile   *string
+	backupOutput    *bool
+	verifyOutput    *bool
 	metricName      *string
+	nameEscaping    *string
+	metricHelp      *string
+	labelFlags      *[]string
+	ouiLabel        *string
+	orgLabel        *string
+	metricTemplate  *string
+	openMetrics     *bool
+	orgBlocks       *bool
+	duplicatePolicy *string
+	joinDelimiter   *string
+
+	normalizeOrganizations *bool
+	organizationCase       *string
+	aliasFile              *string
+	includeOrgRegex        *string
+	excludeOrgRegex        *string
+	includeOUIs            *[]string
+
+	mergedLabel     *bool
+	assignmentLabel *bool
+	checkmkFile     *string
+	healthFile      *string
+
+	organizationHashFile *string
+	hwdbFile             *string
+	jsonlFile            *string
+	yamlFile             *string
+	aclFile              *string
+	aclOrganizations     *[]string
+	clickhouseURL        *string
+	clickhouseTable      *string
+	clickhouseUsername   *string
+	clickhousePassword   *string
+
+	webhookURL    *string
+	summaryFile   *string
+	watchlistFile *string
+	slackURL      *string
+	smtpAddr      *string
+	smtpUsername  *string
+	smtpPassword  *string
+	smtpFrom      *string
+	smtpTo        *[]string
+
+	enrichmentInterval *time.Duration
+	neighborFile       *string
+	neighborMetricName *string
+	dhcpLeasesFile     *string
+	dhcpLeasesFormat   *string
+	keaAPIURL          *string
+	dhcpFile           *string
+	dhcpMetricName     *string
+	captureInterface   *string
+	captureTTL         *time.Duration
+	captureFile        *string
+	captureMetricName  *string
+	snmpTargets        *[]string
+	snmpCommunity      *string
+	snmpVersion        *string
+	snmpFile           *string
+	snmpMetricName     *string
+	unifiURL           *string
+	unifiUsername      *string
+	unifiPassword      *string
+	unifiSite          *string
+	unifiOS            *bool
+	routerOSURLs       *[]string
+	routerOSUsername   *string
+	routerOSPassword   *string
+	controllerInsecure *bool
+	wifiFile           *string
+	wifiMetricName     *string
+	sflowListen        *string
+	sflowFile          *string
+	sflowMetricPrefix  *string
+	nicFile            *string
+	nicMetricName      *string
+	seenOnlyFile       *string
+	seenOnlyNeighbors  *bool
+
+	ethertypeURL         *string
+	ethertypeFile        *string
+	ethertypeMetricName  *string
+	bluetoothURL         *string
+	bluetoothFile        *string
+	bluetoothMetricName  *string
+	usbURL               *string
+	usbFile              *string
+	usbMetricName        *string
+	usbProducts          *bool
+	usbProductMetric     *string
+	pciURL               *string
+	pciFile              *string
+	pciMetricName        *string
+	enterpriseURL        *string
+	enterpriseFile       *string
+	enterpriseMetricName *string
+
+	httpConcurrency   *int
+	maxRedirects      *int
+	sameHostRedirects *bool
+	httpTimeout       *time.Duration
+	maxRetries        *int
+	backoffBase       *time.Duration
+	backoffMax        *time.Duration
+	exitAfterRetries  *bool
+	httpProxy         *string
+	tlsCAFile         *string
+	tlsCertFile       *string
+	tlsKeyFile        *string
+	jitterSeed        *int64
+	timeScale         *float64
+	recordDir         *string
+	replayDir         *string
+
+	profileCPU *string
+	profileMem *string
+
+	rootCmd          *ff.Command
+	httpClient       *http.Client
+	controllerClient *http.Client
+
+	// Source of the random backoff jitter, shared by all refresh loops
+	jitter      *rand.Rand
+	jitterMutex sync.Mutex
+
+	userAgent = binName + "/" + version.Version
+)
+
+// Print program usage and the reason parsing failed, then exit
+func printUsage(cmd *ff.Command, err error) {
+	fmt.Fprintf(os.Stderr, "%s\n", ffhelp.Command(cmd))
+
+	// Report why parsing failed, e.g. an unknown key in the config file
+	if !errors.Is(err, ff.ErrHelp) {
+		fmt.Fprintf(os.Stderr, "error: %s\n", err)
+	}
+
+	os.Exit(1)
+}
+
+// Print program version
+func printVersion() {
+	fmt.Printf("%s v%s built on %s\n", binName, version.Version, version.BuildDate)
+	os.Exit(0)
+}
+
+func init() {
+	fs := ff.NewFlagSet(binName)
+	displayVersion := fs.BoolLong("version", "Print version")
+	configFile = fs.StringLong(
+		"config",
+		"",
+		"TOML config file of flag values, e.g. output-file = \"/var/lib/node_exporter/textfile/oui.prom\", reloaded on SIGHUP",
+	)
+	jobName = fs.StringLong(
+		"job",
+		"",
+		"Name of the job of the config file to run, instead of running all of its jobs",
+	)
+	logLevel = fs.StringEnumLong(
+		"log-level",
+		"Log level: debug, info, warn, error",
+		"info",
+		"debug",
+		"error",
+		"warn",
+	)
+	logFormat = fs.StringEnumLong(
+		"log-format",
+		"Log format: text, json",
+		"text",
+		"json",
+	)
+	logFile = fs.StringLong(
+		"log-file",
+		"",
+		"Path of a file to append logs to instead of writing them to stdout",
+	)
+	refreshInterval = fs.StringLong(
+		"refresh-interval",
+		"168h",
+		`Interval at which to refresh the OUI database. Valid time units are "ns", "us", "ms", "s", "m", "h"`,
+	)
+	refreshSchedule = fs.StringLong(
+		"refresh-schedule",
+		"",
+		`Cron expression of the local times at which to refresh the OUI database, e.g. "0 3 * * 1", instead of --refresh-interval`,
+	)
+	oneshot = fs.BoolLong(
+		"oneshot",
+		"Refresh the OUI database once and exit, with a non-zero exit status on failure, e.g. for cron or systemd timers",
+	)
+	tempDir = fs.StringLong(
+		"temp-dir",
+		"",
+		"Directory where the OUI database is downloaded to (default: the system temporary directory)",
+	)
+	metricFile = fs.StringLong(
+		"output-file",
+		"/var/lib/node_exporter/textfile/oui.prom",
+		"Path to the file where metrics should be written, or empty to only serve them with --exporter-listen",
+	)
+	extraOutputs = fs.StringListLong(
+		"extra-output-file",
+		"Additional path, e.g. on an NFS export, which receives a copy of the metric file on every refresh (repeatable)",
+	)
+	pushgatewayURL = fs.StringLong(
+		"pushgateway-url",
+		"",
+		"URL of a Prometheus Pushgateway which the metrics are pushed to on every refresh, e.g. http://pushgateway:9091",
+	)
+	pushgatewayJob = fs.StringLong(
+		"pushgateway-job",
+		binName,
+		"Job label of the Pushgateway group which the metrics are pushed to",
+	)
+	pushgatewayInstance = fs.StringLong(
+		"pushgateway-instance",
+		"",
+		"Instance label of the Pushgateway group which the metrics are pushed to (default: the host name)",
+	)
+	pushgatewayMetrics = fs.StringEnumLong(
+		"pushgateway-metrics",
+		"Metrics pushed to the Pushgateway: all, or self for the collector self-metrics without the OUIs",
+		"all",
+		"self",
+	)
+	outputMode = fs.StringLong(
+		"output-mode",
+		"0644",
+		"Octal permissions of the output files",
+	)
+	outputOwner = fs.StringLong(
+		"output-owner",
+		"",
+		"User name or ID which owns the output files (default: the user running the collector)",
+	)
+	outputGroup = fs.StringLong(
+		"output-group",
+		"",
+		"Group name or ID which owns the output files (default: the group of the user running the collector)",
+	)
+	outputFormat = fs.StringEnumLong(
+		"output-format",
+		"Format of the output file: prom for Prometheus metrics, json for a JSON lookup table from OUI to organization, sqlite for a SQLite database",
+		"prom",
+		"json",
+		"sqlite",
+	)
+	sqliteDeltaMax = fs.IntLong(
+		"sqlite-delta-max",
+		1000,
+		"Maximum number of changed rows which are applied to an existing SQLite output file in place instead of rebuilding it, 0 to always rebuild it",
+	)
+	exporterListen = fs.StringLong(
+		"exporter-listen",
+		"",
+		"TCP address, e.g. :9877, or unix: and the path of a Unix socket, on which to serve the OUI metrics on /metrics",
+	)
+	exporterMode = fs.StringLong(
+		"exporter-socket-mode",
+		"0660",
+		"Octal permissions of the Unix socket of --exporter-listen",
+	)
+	parseMode = fs.StringEnumLong(
+		"parse-mode",
+		"Handling of malformed rows of the OUI database: lenient skips and counts them, strict fails the update",
+		"lenient",
+		"strict",
+	)
+	ouiSource = fs.StringEnumLong(
+		"source",
+		"Source of the OUI database: ieee for the IEEE registries, wireshark for the Wireshark manuf file",
+		"ieee",
+		"wireshark",
+	)
+	wiresharkURL = fs.StringLong(
+		"wireshark-url",
+		"https://www.wireshark.org/download/automated/data/manuf",
+		"URL of the Wireshark manuf file, used with --source wireshark",
+	)
+	ouiURLs = fs.StringListLong(
+		"oui-url",
+		"URL of the OUI database, overriding that of --source, or a file:// URL; repeat to try mirrors in order until one succeeds",
+	)
+	sourceFile = fs.StringLong(
+		"source-file",
+		"",
+		"Path to a local copy of the OUI database, e.g. mirrored into an air-gapped network, which is read instead of downloading it",
+	)
+	wiresharkShortNames = fs.BoolLong(
+		"wireshark-short-names",
+		"Use the short vendor names of the Wireshark manuf file, e.g. Cisco, instead of the organization names",
+	)
+	registries = fs.StringLong(
+		"registries",
+		"mal",
+		"Comma separated IEEE registries to download and merge: mal, mam (28-bit), mas (36-bit), iab, cid",
+	)
+	mergeDir = fs.StringLong(
+		"merge-dir",
+		"",
+		"Directory of other .prom files which are validated and merged into the metric file",
+	)
+	historyFile = fs.StringLong(
+		"history-file",
+		"",
+		"Path to a state file recording when each OUI first appeared and when its organization last changed, exported as companion metrics",
+	)
+	renameLogFile = fs.StringLong(
+		"rename-log-file",
+		"",
+		"Path to a JSON lines changelog to which OUIs whose organization changed are appended on every refresh",
+	)
+	backupOutput = fs.BoolLong(
+		"backup-output",
+		"Keep the previous generation of the output file next to it, with a .bak suffix",
+	)
+	verifyOutput = fs.BoolLong(
+		"verify-output",
+		"Re-read metric files after replacing them and fail if their contents differ from what was written",
+	)
+	metricName = fs.StringLong(
+		"metric-name",
+		"mac_oui_info",
+		"Prometheus metric name",
+	)
+	ouiLabel = fs.StringLong(
+		"oui-label",
+		"oui",
+		"Name of the label holding the OUI",
+	)
+	orgLabel = fs.StringLong(
+		"org-label",
+		"organization_name",
+		"Name of the label holding the organization name",
+	)
+	metricTemplate = fs.StringLong(
+		"metric-template",
+		"",
+		`Go text/template of the OUI metric lines, e.g. '{{.Name}}{mac_prefix="{{.OUI}}",vendor="{{.Organization}}"} {{.Value}}'`,
+	)
+	labelFlags = fs.StringListLong(
+		"label",
+		"Constant label added to every sample as key=value, e.g. site=ams1 (repeatable)",
+	)
+	metricHelp = fs.StringLong(
+		"metric-help",
+		"Organization which an OUI is assigned to by the IEEE",
+		"HELP text written for the Prometheus metric",
+	)
+	openMetrics = fs.BoolLong(
+		"openmetrics",
+		"Write metric files in the OpenMetrics text format instead of the Prometheus text format",
+	)
+	nameEscaping = fs.StringEnumLong(
+		"name-escaping",
+		"Metric and label name scheme: underscores (legacy names only), utf8 (quoted UTF-8 names for Prometheus 3)",
+		"underscores",
+		"utf8",
+	)
+	duplicatePolicy = fs.StringEnumLong(
+		"duplicate-policy",
+		"Handling of OUIs assigned to multiple organizations: join (one series, names joined with --join-delimiter), records (one series per CSV record)",
+		"join",
+		"records",
+	)
+	joinDelimiter = fs.StringLong(
+		"join-delimiter",
+		" | ",
+		"Separator between organization names joined by the join duplicate policy",
+	)
+	normalizeOrganizations = fs.BoolLong(
+		"normalize-organizations",
+		"Collapse whitespace and trim punctuation from the end of each word of organization names",
+	)
+	organizationCase = fs.StringEnumLong(
+		"organization-case",
+		"Case of organization names: preserve, lower, upper, title",
+		"preserve",
+		"lower",
+		"upper",
+		"title",
+	)
+	aliasFile = fs.StringLong(
+		"alias-file",
+		"",
+		`File of "variant = organization" rules rewriting organization names, e.g. "Apple Inc = Apple"`,
+	)
+	includeOrgRegex = fs.StringLong(
+		"include-org-regex",
+		"",
+		"Only write the OUIs of organizations whose name matches this regular expression",
+	)
+	excludeOrgRegex = fs.StringLong(
+		"exclude-org-regex",
+		"",
+		"Leave out the OUIs of organizations whose name matches this regular expression",
+	)
+	includeOUIs = fs.StringListLong(
+		"include-oui",
+		"Only write this OUI, e.g. 00:00:0c, along with the other included OUIs and organizations (repeatable)",
+	)
+	mergedLabel = fs.BoolLong(
+		"merged-label",
+		`Add a merged="true" or merged="false" label showing whether organization names were joined by the join duplicate policy`,
+	)
+	assignmentLabel = fs.BoolLong(
+		"assignment-label",
+		"Add an assignment label holding the Assignment column of the OUI CSV file verbatim",
+	)
+	organizationHashFile = fs.StringLong(
+		"organization-hash-file",
+		"",
+		"Replace organization names with short hashes and write the mapping from hash to name to this metric file",
+	)
+	hwdbFile = fs.StringLong(
+		"hwdb-file",
+		"",
+		"Path to the file where the OUI database should be written as a udev hwdb fragment",
+	)
+	jsonlFile = fs.StringLong(
+		"jsonl-file",
+		"",
+		"Path to the file where the OUI database should be written as JSON Lines",
+	)
+	yamlFile = fs.StringLong(
+		"yaml-file",
+		"",
+		"Path to the file where the OUI database should be written as YAML",
+	)
+	aclFile = fs.StringLong(
+		"acl-file",
+		"",
+		"Path to the file where a hostapd accept_mac_file style ACL with the OUIs of the --acl-organization organizations should be written",
+	)
+	aclOrganizations = fs.StringListLong(
+		"acl-organization",
+		"Regular expression matching organization names whose OUIs are written to the ACL file (repeatable)",
+	)
+	clickhouseURL = fs.StringLong(
+		"clickhouse-url",
+		"",
+		"URL of the ClickHouse HTTP interface into which the OUI database is loaded after each refresh",
+	)
+	clickhouseTable = fs.StringLong(
+		"clickhouse-table",
+		"oui",
+		"ClickHouse table whose contents are replaced with the OUI database",
+	)
+	clickhouseUsername = fs.StringLong(
+		"clickhouse-username",
+		"",
+		"Username for ClickHouse",
+	)
+	clickhousePassword = fs.StringLong(
+		"clickhouse-password",
+		"",
+		"Password for ClickHouse",
+	)
+	orgBlocks = fs.BoolLong(
+		"organization-blocks",
+		"Also emit the number of OUI blocks held by each organization",
+	)
+	healthFile = fs.StringLong(
+		"health-file",
+		"",
+		"Path to the file where collector health metrics should be written, even when refreshes fail",
+	)
+	checkmkFile = fs.StringLong(
+		"checkmk-file",
+		"",
+		"Path to a CheckMK spool file where a local check summarizing the OUI database should be written",
+	)
+	summaryFile = fs.StringLong(
+		"summary-file",
+		"",
+		`Path to the file where a JSON summary of each refresh attempt should be written, or "-" for stdout`,
+	)
+	webhookURL = fs.StringLong(
+		"webhook-url",
+		"",
+		"URL to which a JSON summary is POSTed after each successful refresh",
+	)
+	watchlistFile = fs.StringLong(
+		"watchlist-file",
+		"",
+		"Path to a file listing OUIs and organization names whose changes should be reported",
+	)
+	enrichmentInterval = fs.DurationLong(
+		"enrichment-interval",
+		time.Minute,
+		"Interval at which to refresh the enrichment metric files",
+	)
+	neighborFile = fs.StringLong(
+		"neighbor-file",
+		"",
+		"Path to the file where vendor metrics for MAC addresses in the local neighbor table should be written",
+	)
+	neighborMetricName = fs.StringLong(
+		"neighbor-metric-name",
+		"mac_vendor_info",
+		"Prometheus metric name for neighbor vendor metrics",
+	)
+	dhcpLeasesFile = fs.StringLong(
+		"dhcp-leases-file",
+		"",
+		"Path to a DHCP server lease file",
+	)
+	dhcpLeasesFormat = fs.StringEnumLong(
+		"dhcp-leases-format",
+		"Format of the DHCP server lease file: isc, dnsmasq, kea",
+		"isc",
+		"dnsmasq",
+		"kea",
+	)
+	keaAPIURL = fs.StringLong(
+		"kea-api-url",
+		"",
+		"URL of a Kea control agent to query for DHCP leases instead of reading a lease file",
+	)
+	dhcpFile = fs.StringLong(
+		"dhcp-file",
+		"",
+		"Path to the file where vendor metrics for DHCP leases should be written",
+	)
+	dhcpMetricName = fs.StringLong(
+		"dhcp-metric-name",
+		"dhcp_lease_vendor_info",
+		"Prometheus metric name for DHCP lease vendor metrics",
+	)
+	captureInterface = fs.StringLong(
+		"capture-interface",
+		"",
+		"Network interface on which to passively capture source MAC addresses",
+	)
+	captureTTL = fs.DurationLong(
+		"capture-ttl",
+		time.Hour,
+		"Time after which a captured or sFlow sampled MAC address which hasn't been seen again is forgotten",
+	)
+	captureFile = fs.StringLong(
+		"capture-file",
+		"",
+		"Path to the file where vendor counts of captured MAC addresses should be written",
+	)
+	captureMetricName = fs.StringLong(
+		"capture-metric-name",
+		"mac_vendor_devices",
+		"Prometheus metric name for captured device counts",
+	)
+	snmpTargets = fs.StringListLong(
+		"snmp-target",
+		"Switch (host or host:port) whose MAC forwarding table should be polled over SNMP (repeatable)",
+	)
+	snmpCommunity = fs.StringLong(
+		"snmp-community",
+		"public",
+		"SNMP community",
+	)
+	snmpVersion = fs.StringEnumLong(
+		"snmp-version",
+		"SNMP version: 2c, 1",
+		"2c",
+		"1",
+	)
+	snmpFile = fs.StringLong(
+		"snmp-file",
+		"",
+		"Path to the file where vendor metrics for switch forwarding tables should be written",
+	)
+	snmpMetricName = fs.StringLong(
+		"snmp-metric-name",
+		"snmp_fdb_vendor_info",
+		"Prometheus metric name for switch forwarding table vendor metrics",
+	)
+	unifiURL = fs.StringLong(
+		"unifi-url",
+		"",
+		"URL of a UniFi network controller to query for wireless clients",
+	)
+	unifiUsername = fs.StringLong(
+		"unifi-username",
+		"",
+		"Username for the UniFi controller",
+	)
+	unifiPassword = fs.StringLong(
+		"unifi-password",
+		"",
+		"Password for the UniFi controller",
+	)
+	unifiSite = fs.StringLong(
+		"unifi-site",
+		"default",
+		"UniFi site to query",
+	)
+	unifiOS = fs.BoolLong(
+		"unifi-os",
+		"The UniFi controller runs on a UniFi OS console (UDM, Cloud Key Gen2+)",
+	)
+	routerOSURLs = fs.StringListLong(
+		"routeros-url",
+		"URL of a MikroTik RouterOS REST API to query for wireless clients (repeatable)",
+	)
+	routerOSUsername = fs.StringLong(
+		"routeros-username",
+		"",
+		"Username for the RouterOS REST API",
+	)
+	routerOSPassword = fs.StringLong(
+		"routeros-password",
+		"",
+		"Password for the RouterOS REST API",
+	)
+	controllerInsecure = fs.BoolLong(
+		"controller-insecure-skip-verify",
+		"Don't verify the TLS certificates of UniFi controllers and RouterOS devices",
+	)
+	wifiFile = fs.StringLong(
+		"wifi-file",
+		"",
+		"Path to the file where vendor counts of wireless clients should be written",
+	)
+	wifiMetricName = fs.StringLong(
+		"wifi-metric-name",
+		"wifi_client_vendor_clients",
+		"Prometheus metric name for wireless client vendor counts",
+	)
+	sflowListen = fs.StringLong(
+		"sflow-listen",
+		"",
+		"UDP address on which to listen for sFlow datagrams, e.g. :6343",
+	)
+	sflowFile = fs.StringLong(
+		"sflow-file",
+		"",
+		"Path to the file where vendor traffic counters from sFlow should be written",
+	)
+	sflowMetricPrefix = fs.StringLong(
+		"sflow-metric-prefix",
+		"sflow_vendor",
+		"Prefix of the Prometheus metric names for sFlow vendor counters",
+	)
+	nicFile = fs.StringLong(
+		"nic-file",
+		"",
+		"Path to the file where vendor metrics for this host's physical network interfaces should be written",
+	)
+	nicMetricName = fs.StringLong(
+		"nic-metric-name",
+		"node_nic_vendor_info",
+		"Prometheus metric name for network interface vendor metrics",
+	)
+	seenOnlyFile = fs.StringLong(
+		"seen-only-file",
+		"",
+		"Path to a file listing MAC addresses or OUIs; only these OUIs are written to the metric file",
+	)
+	seenOnlyNeighbors = fs.BoolLong(
+		"seen-only-neighbors",
+		"Only write OUIs present in the local neighbor table to the metric file",
+	)
+	ethertypeURL = fs.StringLong(
+		"ethertype-url",
+		"https://standards-oui.ieee.org/ethertype/eth.csv",
+		"URL of the IEEE EtherType registry CSV file",
+	)
+	ethertypeFile = fs.StringLong(
+		"ethertype-file",
+		"",
+		"Path to the file where EtherType metrics should be written",
+	)
+	ethertypeMetricName = fs.StringLong(
+		"ethertype-metric-name",
+		"ethertype_info",
+		"Prometheus metric name for EtherType metrics",
+	)
+	bluetoothURL = fs.StringLong(
+		"bluetooth-url",
+		"https://bitbucket.org/bluetooth-SIG/public/raw/main/assigned_numbers/company_identifiers/company_identifiers.yaml",
+		"URL of the Bluetooth SIG company identifiers YAML file",
+	)
+	bluetoothFile = fs.StringLong(
+		"bluetooth-file",
+		"",
+		"Path to the file where Bluetooth company metrics should be written",
+	)
+	bluetoothMetricName = fs.StringLong(
+		"bluetooth-metric-name",
+		"bluetooth_company_info",
+		"Prometheus metric name for Bluetooth company metrics",
+	)
+	usbURL = fs.StringLong(
+		"usb-url",
+		"http://www.linux-usb.org/usb.ids",
+		"URL of the usb.ids file",
+	)
+	usbFile = fs.StringLong(
+		"usb-file",
+		"",
+		"Path to the file where USB vendor metrics should be written",
+	)
+	usbMetricName = fs.StringLong(
+		"usb-metric-name",
+		"usb_vendor_info",
+		"Prometheus metric name for USB vendor metrics",
+	)
+	usbProducts = fs.BoolLong(
+		"usb-products",
+		"Also emit a series for each USB product",
+	)
+	usbProductMetric = fs.StringLong(
+		"usb-product-metric-name",
+		"usb_product_info",
+		"Prometheus metric name for USB product metrics",
+	)
+	pciURL = fs.StringLong(
+		"pci-url",
+		"https://pci-ids.ucw.cz/v2.2/pci.ids",
+		"URL of the pci.ids file",
+	)
+	pciFile = fs.StringLong(
+		"pci-file",
+		"",
+		"Path to the file where PCI vendor metrics should be written",
+	)
+	pciMetricName = fs.StringLong(
+		"pci-metric-name",
+		"pci_vendor_info",
+		"Prometheus metric name for PCI vendor metrics",
+	)
+	enterpriseURL = fs.StringLong(
+		"enterprise-url",
+		"https://www.iana.org/assignments/enterprise-numbers.txt",
+		"URL of the IANA Private Enterprise Numbers registry",
+	)
+	enterpriseFile = fs.StringLong(
+		"enterprise-file",
+		"",
+		"Path to the file where SNMP enterprise metrics should be written",
+	)
+	enterpriseMetricName = fs.StringLong(
+		"enterprise-metric-name",
+		"snmp_enterprise_info",
+		"Prometheus metric name for SNMP enterprise metrics",
+	)
+	slackURL = fs.StringLong(
+		"slack-webhook-url",
+		"",
+		"Slack incoming webhook URL to which changes are reported after each refresh",
+	)
+	smtpAddr = fs.StringLong(
+		"smtp-addr",
+		"",
+		"Address (host:port) of an SMTP server through which changes are reported after each refresh",
+	)
+	smtpUsername = fs.StringLong(
+		"smtp-username",
+		"",
+		"Username for SMTP authentication",
+	)
+	smtpPassword = fs.StringLong(
+		"smtp-password",
+		"",
+		"Password for SMTP authentication",
+	)
+	smtpFrom = fs.StringLong(
+		"smtp-from",
+		binName+"@localhost",
+		"Sender address of change report emails",
+	)
+	smtpTo = fs.StringListLong(
+		"smtp-to",
+		"Recipient address of change report emails (repeatable)",
+	)
+	httpConcurrency = fs.IntLong(
+		"http-concurrency",
+		2,
+		"Maximum number of concurrent HTTP requests",
+	)
+	maxRedirects = fs.IntLong(
+		"max-redirects",
+		10,
+		"Maximum number of HTTP redirects to follow",
+	)
+	sameHostRedirects = fs.BoolLong(
+		"same-host-redirects",
+		"Refuse HTTP redirects to a host other than the one of the configured URL",
+	)
+	httpTimeout = fs.DurationLong(
+		"http-timeout",
+		30*time.Second,
+		"Timeout of an HTTP request, including reading the response body",
+	)
+	maxRetries = fs.IntLong(
+		"max-retries",
+		3,
+		"Maximum number of times a download is retried within a refresh when the server answers 429 or a 5xx status",
+	)
+	backoffBase = fs.DurationLong(
+		"backoff-base",
+		4*time.Second,
+		"Time to wait before the first retry, which doubles for every further retry",
+	)
+	backoffMax = fs.DurationLong(
+		"backoff-max",
+		24*time.Hour,
+		"Maximum time to wait before a retry",
+	)
+	exitAfterRetries = fs.BoolLong(
+		"exit-after-retries",
+		"Exit with an error when a refresh fails after --max-retries retries, instead of retrying the refresh with backoff",
+	)
+	httpProxy = fs.StringLong(
+		"http-proxy",
+		"",
+		"URL of the HTTP proxy for all requests (default: from the HTTP_PROXY, HTTPS_PROXY and NO_PROXY environment variables)",
+	)
+	tlsCAFile = fs.StringLong(
+		"tls-ca-file",
+		"",
+		"Path to a PEM bundle of CA certificates trusted in addition to the system ones, e.g. of a TLS intercepting proxy",
+	)
+	tlsCertFile = fs.StringLong(
+		"tls-cert-file",
+		"",
+		"Path to a PEM client certificate presented to servers which require one",
+	)
+	tlsKeyFile = fs.StringLong(
+		"tls-key-file",
+		"",
+		"Path to the PEM private key of --tls-cert-file",
+	)
+	jitterSeed = fs.Int64Long(
+		"jitter-seed",
+		0,
+		"Seed for the random backoff jitter, for reproducible tests (default: random)",
+	)
+	timeScale = fs.Float64Long(
+		"time-scale",
+		1,
+		"Factor by which refresh intervals and backoff run faster than real time (for testing only)",
+	)
+	recordDir = fs.StringLong(
+		"record-dir",
+		"",
+		"Directory where the raw HTTP responses to downloads are recorded",
+	)
+	replayDir = fs.StringLong(
+		"replay-dir",
+		"",
+		"Directory from which HTTP responses recorded with --record-dir are replayed instead of downloading",
+	)
+	profileCPU = fs.StringLong(
+		"profile-cpu",
+		"",
+		"Path to the file where a CPU profile should be written",
+	)
+	profileMem = fs.StringLong(
+		"profile-mem",
+		"",
+		"Path to the file where a memory profile should be written on exit",
+	)
+
+	rootCmd = &ff.Command{
+		Name:  binName,
+		Usage: binName + " [FLAGS] [<SUBCOMMAND> ...]",
+		Flags: fs,
+		Exec:  collect,
+		Subcommands: []*ff.Command{
+			newBenchCommand(fs),
+			newCheckCommand(fs),
+			newValidateConfigCommand(fs),
+			newCompletionCommand(fs),
+			newMangenCommand(fs),
+			newLookupCommand(fs),
+		},
+	}
+
+	err := rootCmd.Parse(os.Args[1:], parseOptions()...)
+	if err != nil {
+		printUsage(rootCmd, err)
+	}
+
+	if *configFile != "" {
+		// Keep the config file to restore it if a reload is invalid
+		configContent, _ = os.ReadFile(*configFile)
+	}
+
+	if *displayVersion {
+		printVersion()
+	}
+
+	// The validate-config subcommand reports invalid flags itself, and
+	// completion scripts and references don't depend on the configuration.
+	// None of them make HTTP requests.
+	switch rootCmd.GetSelected().Name {
+	case "validate-config", "completion", "mangen":
+	default:
+		err := validateJobs()
+		if supervisingJobs() {
+			err = errors.Join(err, validateJobFlags(false))
+		} else {
+			err = errors.Join(err, validateFlags())
+		}
+
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s\n", err)
+			os.Exit(1)
+		}
+
+		if err := setupHTTPClients(); err != nil {
+			fmt.Fprintf(os.Stderr, "%s\n", err)
+			os.Exit(1)
+		}
+	}
+
+	seed := *jitterSeed
+	if seed == 0 {
+		seed = time.Now().UnixNano()
+	}
+
+	jitter = rand.New(rand.NewSource(seed))
+
+	setLogLevel()
+
+	logOutput := io.Writer(os.Stdout)
+	if *logFile != "" {
+		f, err := os.OpenFile(*logFile, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o644)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error opening log file: %s\n", err)
+			os.Exit(1)
+		}
+
+		logOutput = f
+	}
+
+	handlerOptions := &slog.HandlerOptions{
+		Level: slogLevel,
+	}
+
+	var handler slog.Handler = slog.NewTextHandler(logOutput, handlerOptions)
+	if *logFormat == "json" {
+		handler = slog.NewJSONHandler(logOutput, handlerOptions)
+	}
+
+	logger := slog.New(handler)
+	if *jobName != "" {
+		logger = logger.With("job", *jobName)
+	}
+
+	slog.SetDefault(logger)
+}
+
+// Set the level of the logger from the flags
+func setLogLevel() {
+	switch *logLevel {
+	case "debug":
+		slogLevel.Set(slog.LevelDebug)
+	case "info":
+		slogLevel.Set(slog.LevelInfo)
+	case "warn":
+		slogLevel.Set(slog.LevelWarn)
+	case "error":
+		slogLevel.Set(slog.LevelError)
+	}
+}
+
+// A downloaded copy of the OUI database or another registry
+type download struct {
+	// URL the registry was downloaded from
+	url          string
+	filename     string
+	lastModified time.Time
+	sha256       string
+	fetch        fetchDetails
+	validator    cacheValidator
+	// The server answered 304 Not Modified, so nothing was downloaded
+	notModified bool
+	// Time to wait before retrying, as asked by the server
+	retryAfter time.Duration
+	// Time taken to download all the registries
+	duration time.Duration
+	// Other IEEE registries downloaded along with MA-L
+	registries []registryDownload
+}
+
+// Download the OUI database
+func update(ctx context.Context) (download, error) {
+	start := time.Now()
+
+	dl, err := fetchSource(ctx)
+	if err != nil {
+		if ctx.Err() == nil {
+			registryFailed("mal")
+		}
+
+		return dl, err
+	}
+
+	dl.registries, err = fetchExtraRegistries(ctx)
+	if err != nil {
+		os.Remove(dl.filename)
+		return dl, err
+	}
+
+	unchanged := dl.notModified && !slices.ContainsFunc(dl.registries, func(r registryDownload) bool { return !r.notModified })
+
+	// Outputs which are rebuilt between refreshes need the OUI database
+	// loaded, which it isn't after validators were loaded on startup
+	if unchanged && ouiRecords == nil && needsLoadedDatabase() {
+		unchanged = false
+	}
+
+	if !unchanged {
+		err = refetchUnmodified(ctx, &dl)
+		if err != nil && ctx.Err() == nil {
+			registryFailed("mal")
+		}
+	}
+
+	dl.duration = time.Since(start)
+
+	return dl, err
+}
+
+// Download the OUI database from the first of its URLs which succeeds. If all
+// of them fail, the error of the last one is returned.
+func fetchSource(ctx context.Context) (download, error) {
+	urls := sourceURLs()
+
+	var dl download
+	var err error
+
+	for i, source := range urls {
+		dl, err = fetch(ctx, source, sourcePattern())
+		if err == nil || ctx.Err() != nil || len(urls) == 1 {
+			break
+		}
+
+		slog.Warn("Error downloading OUI database from URL", "url", source, "error", err.Error())
+
+		if i < len(urls)-1 {
+			os.Remove(dl.filename)
+		}
+	}
+
+	if err != nil && ctx.Err() == nil && len(urls) > 1 {
+		return dl, fmt.Errorf("error downloading OUI database from all %d URLs, last error: %w", len(urls), err)
+	}
+
+	return dl, err
+}
+
+// A changed registry is parsed along with all the others, so download the
+// registries which weren't modified again in full
+func refetchUnmodified(ctx context.Context, dl *download) error {
+	if dl.notModified {
+		forgetValidator(dl.url)
+
+		full, err := fetch(ctx, dl.url, sourcePattern())
+		if err != nil {
+			os.Remove(full.filename)
+			removeRegistryDownloads(dl.registries)
+
+			return err
+		}
+
+		full.registries = dl.registries
+		*dl = full
+	}
+
+	registries := []registryDownload{}
+
+	for _, r := range dl.registries {
+		if !r.notModified {
+			registries = append(registries, r)
+
+			continue
+		}
+
+		forgetValidator(r.registry.url)
+
+		full, err := fetch(ctx, r.registry.url, r.registry.name+".csv")
+		if err != nil {
+			os.Remove(full.filename)
+
+			if ctx.Err() != nil {
+				os.Remove(dl.filename)
+				removeRegistryDownloads(dl.registries)
+
+				return fmt.Errorf("error downloading %s registry: %w", r.registry.name, err)
+			}
+
+			// The registry keeps its previous records, as if it had failed
+			// to download in the first place
+			registryFailed(r.registry.name)
+			slog.Warn("Error downloading registry, keeping its previous records", "registry", r.registry.name, "error", err.Error())
+
+			continue
+		}
+
+		registries = append(registries, registryDownload{registry: r.registry, filename: full.filename, validator: full.validator})
+	}
+
+	dl.registries = registries
+
+	return nil
+}
+
+// Download a registry to a temporary file whose name starts with pattern.
+// Requests which the server answers with 429 Too Many Requests or a 5xx
+// status are retried up to --max-retries times, waiting as long as the
+// Retry-After header asks or otherwise backing off.
+func fetch(ctx context.Context, source string, pattern string) (download, error) {
+	for attempt := 0; ; attempt++ {
+		dl, err := fetchOnce(ctx, source, pattern)
+
+		status := dl.fetch.statusCode
+		if err == nil || attempt >= *maxRetries || (status != http.StatusTooManyRequests && status < 500) {
+			return dl, err
+		}
+
+		os.Remove(dl.filename)
+
+		wait := backoff(attempt)
+		if dl.retryAfter > 0 {
+			wait = scaleDuration(min(dl.retryAfter, *backoffMax))
+		}
+
+		slog.Warn("Error downloading registry, retrying", "url", source, "error", err.Error(), "retry", wait)
+
+		select {
+		case <-time.After(wait):
+		case <-ctx.Done():
+			return download{}, ctx.Err()
+		}
+	}
+}
+
+// Parse a Retry-After header, which holds either a number of seconds or a
+// time, into the time to wait
+func parseRetryAfter(value string) time.Duration {
+	if seconds, err := strconv.Atoi(value); err == nil {
+		return time.Duration(seconds) * time.Second
+	}
+
+	if t, err := http.ParseTime(value); err == nil {
+		return time.Until(t)
+	}
+
+	return 0
+}
+
+// Download a registry once, see fetch
+func fetchOnce(ctx context.Context, source string, pattern string) (download, error) {
+	if path, ok := localPath(source); ok {
+		return fetchFile(source, path, pattern)
+	}
+
+	f, err := os.CreateTemp(*tempDir, pattern)
+	if err != nil {
+		return download{}, fmt.Errorf("error creating temporary file: %w", err)
+	}
+	defer f.Close()
+
+	dl := download{
+		url:      source,
+		filename: f.Name(),
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, source, nil)
+	if err != nil {
+		return dl, fmt.Errorf("error creating http request: %w", err)
+	}
+
+	req.Header.Set("User-Agent", userAgent)
+	setConditionalHeaders(req, source)
+	req = req.WithContext(httptrace.WithClientTrace(req.Context(), dl.fetch.trace()))
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return dl, fmt.Errorf("error doing http request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	dl.fetch.statusCode = resp.StatusCode
+	dl.fetch.redirects = countRedirects(resp)
+
+	if resp.StatusCode == http.StatusNotModified {
+		os.Remove(dl.filename)
+
+		dl.filename = ""
+		dl.notModified = true
+		dl.validator = cachedValidator(source)
+		dl.sha256 = dl.validator.sha256
+
+		return dl, nil
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		dl.retryAfter = parseRetryAfter(resp.Header.Get("Retry-After"))
+
+		return dl, withClass("http-status", fmt.Errorf("unexpected http status: %s", resp.Status))
+	}
+
+	if lastModified := resp.Header.Get("Last-Modified"); lastModified != "" {
+		t, err := http.ParseTime(lastModified)
+		if err != nil {
+			slog.Warn("Error parsing Last-Modified header", "last_modified", lastModified)
+		} else {
+			dl.lastModified = t
+		}
+	}
+
+	hash := sha256.New()
+
+	dl.fetch.responseBytes, err = io.Copy(io.MultiWriter(f, hash), resp.Body)
+	if errors.Is(err, io.ErrUnexpectedEOF) {
+		return dl, withClass("truncated", fmt.Errorf("error reading http response: %w", err))
+	}
+
+	if err != nil {
+		return dl, fmt.Errorf("error writing to temporary file: %w", err)
+	}
+
+	dl.sha256 = hex.EncodeToString(hash.Sum(nil))
+	dl.validator = cacheValidator{
+		etag:         resp.Header.Get("ETag"),
+		lastModified: resp.Header.Get("Last-Modified"),
+		sha256:       dl.sha256,
+	}
+
+	return dl, nil
+}
+
+// Remove the temporary files of a download
+func removeDownload(dl download) {
+	if dl.filename != "" {
+		os.Remove(dl.filename)
+	}
+
+	removeRegistryDownloads(dl.registries)
+}
+
+// A single record of the OUI CSV file
+type ouiRecord struct {
+	registry     string
+	assignment   string
+	oui          string
+	prefix       string
+	organization string
+}
+
+// The key identifying the block of a record: the OUI, or the prefix of a
+// 28-bit or 36-bit assignment
+func (r ouiRecord) key() string {
+	if r.prefix != "" {
+		return r.prefix
+	}
+
+	return r.oui
+}
+
+// Parse an OUI CSV file into its records. In lenient mode, if strict parsing
+// fails, the file is parsed again with relaxed quoting and field count rules,
+// so that a minor upstream quoting bug doesn't block refreshes.
+func parse(filename string, registry ieeeRegistry) ([]ouiRecord, error) {
+	records, lines, err := readRecords(filename, false, registry)
+
+	var csvErr *csv.ParseError
+	if errors.As(err, &csvErr) || err == nil {
+		// Every malformed row was found, as reading continues past them
+		if err := checkMalformedRows("OUI CSV file", lines); err != nil {
+			return nil, err
+		}
+	}
+
+	if err == nil || len(lines) == 0 {
+		return records, err
+	}
+
+	slog.Warn(
+		"Error parsing OUI CSV file, retrying with relaxed parsing",
+		"error",
+		err.Error(),
+		"malformed_rows",
+		len(lines),
+		"lines",
+		lines[:min(len(lines), 20)],
+	)
+
+	records, _, err = readRecords(filename, true, registry)
+
+	return records, err
+}
+
+// Number of malformed rows which lenient parsing found since startup, whether
+// they were skipped or read with relaxed quoting
+var parseErrors int
+
+// Handle the malformed rows found while parsing a registry as configured by
+// --parse-mode: fail the update in strict mode, or count them in lenient mode
+func checkMalformedRows(name string, lines []int) error {
+	if len(lines) == 0 {
+		return nil
+	}
+
+	if *parseMode == "strict" {
+		return withClass("parse", fmt.Errorf(
+			"error parsing %s: %d malformed rows, on lines %v",
+			name,
+			len(lines),
+			lines[:min(len(lines), 20)],
+		))
+	}
+
+	parseErrors += len(lines)
+
+	return nil
+}
+
+// Read the records of an OUI CSV file, returning the lines of the malformed
+// rows which were skipped. In strict mode, rows with malformed quoting fail
+// reading, which continues past them so that the lines of all malformed rows
+// can be returned along with the first error.
+func readRecords(filename string, relaxed bool, registry ieeeRegistry) ([]ouiRecord, []int, error) {
+	input, err := os.Open(filename)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error opening OUI CSV file: %w", err)
+	}
+	defer input.Close()
+
+	records := []ouiRecord{}
+	lines := []int{}
+	var parseErr error
 
-	userAgent = binName + "/" + version.Version
-)
+	decoder := ouidb.NewDecoder(input, relaxed)
 
-// Print program usage
-func printUsage(fs ff.Flags) {
-	fmt.Fprintf(os.Stderr, "%s\n", ffhelp.Flags(fs))
-	os.Exit(1)
-}
+	for {
+		entry, err := decoder.Next()
+		if err == io.EOF {
+			break
+		}
 
-// Print program version
-func printVersion() {
-	fmt.Printf("%s v%s built on %s\n", binName, version.Version, version.BuildDate)
-	os.Exit(0)
-}
+		var csvErr *csv.ParseError
+		if !relaxed && errors.As(err, &csvErr) {
+			lines = append(lines, csvErr.StartLine)
 
-func init() {
-	fs := ff.NewFlagSet(binName)
-	displayVersion := fs.BoolLong("version", "Print version")
-	logLevel = fs.StringEnumLong(
-		"log-level",
-		"Log level: debug, info, warn, error",
-		"info",
-		"debug",
-		"error",
-		"warn",
-	)
-	refreshInterval = fs.StringLong(
-		"refresh-interval",
-		"168h",
-		`Interval at which to refresh the OUI database. Valid time units are "ns", "us", "ms", "s", "m", "h"`,
-	)
-	metricFile = fs.StringLong(
-		"output-file",
-		"/var/lib/node_exporter/textfile/oui.prom",
-		"Path to the file where metrics should be written",
-	)
-	metricName = fs.StringLong(
-		"metric-name",
-		"mac_oui_info",
-		"Prometheus metric name",
-	)
+			if parseErr == nil {
+				parseErr = err
+			}
 
-	err := ff.Parse(fs, os.Args[1:],
-		ff.WithEnvVarPrefix(strings.ToUpper(binName)),
-		ff.WithEnvVarSplit(" "),
-	)
-	if err != nil {
-		printUsage(fs)
+			continue
+		}
+
+		var fieldsErr *ouidb.FieldCountError
+		if errors.As(err, &fieldsErr) {
+			slog.Error("OUI CSV row has too few fields", "line", fieldsErr.Line)
+			lines = append(lines, fieldsErr.Line)
+
+			continue
+		}
+
+		if err != nil {
+			return nil, lines, withClass("parse", fmt.Errorf("error parsing OUI CSV file: %w", err))
+		}
+
+		oui := strings.ToLower(entry.Assignment)
+		organization := entry.Organization
+
+		if len(oui) != registry.digits {
+			slog.Error("OUI has wrong number of characters", "oui", oui, "registry", registry.name, "line", entry.Line)
+			lines = append(lines, entry.Line)
+
+			continue
+		}
+
+		if strings.Trim(oui, "0123456789abcdef") != "" {
+			slog.Error("OUI has characters which aren't hex digits", "oui", oui, "registry", registry.name, "line", entry.Line)
+			lines = append(lines, entry.Line)
+
+			continue
+		}
+
+		record := ouiRecord{
+			registry:     registry.name,
+			assignment:   entry.Assignment,
+			oui:          ouidb.FormatPrefix(oui[0:6]),
+			organization: organization,
+		}
+
+		if registry.digits > 6 {
+			record.prefix = ouidb.FormatPrefix(oui)
+		}
+
+		records = append(records, record)
 	}
 
-	if *displayVersion {
-		printVersion()
+	if parseErr != nil {
+		return nil, lines, withClass("parse", fmt.Errorf("error parsing OUI CSV file: %w", parseErr))
 	}
 
-	switch *logLevel {
-	case "debug":
-		slogLevel.Set(slog.LevelDebug)
-	case "info":
-		slogLevel.Set(slog.LevelInfo)
-	case "warn":
-		slogLevel.Set(slog.LevelWarn)
-	case "error":
-		slogLevel.Set(slog.LevelError)
+	return records, lines, nil
+}
+
+// Group records by OUI, in the order in which each OUI first appears
+func groupRecords(records []ouiRecord) [][]ouiRecord {
+	groups := [][]ouiRecord{}
+	index := map[string]int{}
+
+	for _, r := range records {
+		if i, exists := index[r.key()]; exists {
+			groups[i] = append(groups[i], r)
+		} else {
+			index[r.key()] = len(groups)
+			groups = append(groups, []ouiRecord{r})
+		}
 	}
 
-	logger := slog.New(
-		slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{
-			Level: slogLevel,
-		}),
-	)
-	slog.SetDefault(logger)
+	return groups
 }
 
-func update() (string, error) {
-	f, err := os.CreateTemp("", "oui.csv")
-	if err != nil {
-		return "", fmt.Errorf("error creating temporary file: %w", err)
+// Join the organization names of a group of records for the same OUI
+func joinOrganizations(group []ouiRecord) string {
+	organizations := make([]string, 0, len(group))
+
+	for _, r := range group {
+		organizations = append(organizations, r.organization)
 	}
-	defer f.Close()
 
-	filename := f.Name()
+	return strings.Join(organizations, *joinDelimiter)
+}
+
+// Build a map of OUI to organization name, merging organization names if
+// multiple exist for same OUI
+func mergeRecords(records []ouiRecord) map[string]string {
+	ouiMap := map[string]string{}
 
-	client := http.Client{
-		Timeout: 30 * time.Second,
+	for _, group := range groupRecords(records) {
+		ouiMap[group[0].key()] = joinOrganizations(group)
 	}
 
-	req, err := http.NewRequestWithContext(context.Background(), http.MethodGet, url, nil)
+	return ouiMap
+}
+
+// Parse a downloaded OUI database and the other registries downloaded with it
+// into records with their organizations rewritten
+func parseDownload(dl download) ([]ouiRecord, error) {
+	records, err := parseSource(dl.filename)
 	if err != nil {
-		return filename, fmt.Errorf("error creating http request: %w", err)
+		registryFailed("mal")
+
+		return nil, err
 	}
 
-	req.Header.Set("User-Agent", userAgent)
+	registrySucceeded("mal")
 
-	resp, err := client.Do(req)
-	if err != nil {
-		return filename, fmt.Errorf("error doing http request: %w", err)
+	records = append(records, parseExtraRegistries(dl)...)
+
+	if len(records) == 0 {
+		return nil, withClass("validation", fmt.Errorf("OUI CSV file contains no valid entries"))
 	}
-	defer resp.Body.Close()
 
-	_, err = io.Copy(f, resp.Body)
-	if err != nil {
-		return filename, fmt.Errorf("error writing to temporary file: %w", err)
+	if err := rewriteOrganizations(records); err != nil {
+		return nil, err
 	}
 
-	return filename, nil
+	return records, nil
 }
 
-func parse(filename string) error {
-	input, err := os.Open(filename)
+// Parse a downloaded OUI database and write it to the metric file, returning
+// the OUIs written, the changes to watched OUIs and the updated OUI history,
+// which are recorded once the refresh is published. The number of failed
+// refreshes so far is written along with the OUIs.
+func generate(ctx context.Context, dl download, previous map[string]string, metricFile string, failures int) (map[string]string, []watchedChange, map[string]ouiHistory, error) {
+	records, err := parseDownload(dl)
 	if err != nil {
-		return fmt.Errorf("error opening OUI CSV file: %w", err)
+		return nil, nil, nil, err
 	}
-	defer input.Close()
 
-	output, err := os.Create(*metricFile + ".tmp")
-	if err != nil {
-		return fmt.Errorf("error opening temporary OUI metric file: %w", err)
+	ouiMap := mergeRecords(records)
+
+	samples := []sample{buildInfoSample()}
+	samples = append(samples, updateSamples(time.Now(), failures, dl.duration)...)
+
+	if *orgBlocks {
+		samples = append(samples, organizationBlockSamples(ouiMap)...)
 	}
-	defer output.Close()
 
-	ouiMap := map[string]string{}
+	if !dl.lastModified.IsZero() {
+		samples = append(samples, sample{
+			name:  metricNameWithSuffix("source_last_modified_timestamp_seconds"),
+			value: float64(dl.lastModified.Unix()),
+		})
+	}
 
-	first := true
-	csv := csv.NewReader(input)
+	samples = append(samples, freshnessSamples(dl)...)
 
-	for {
-		entry, err := csv.Read()
-		if err == io.EOF {
-			break
+	changes := diffOUIs(previous, ouiMap)
+	samples = append(samples, renameSamples(changes)...)
+	samples = append(samples, entryChangeSamples(changes)...)
+
+	history := map[string]ouiHistory(nil)
+	if *historyFile != "" {
+		history, err = loadHistory(*historyFile, ouiMap)
+		if err != nil {
+			slog.Error("Error tracking OUI history", "error", err.Error())
 		}
+	}
 
+	watched := []watchedChange{}
+	if *watchlistFile != "" {
+		watched, err = findWatchedChanges(*watchlistFile, changes, previous, ouiMap)
 		if err != nil {
-			return fmt.Errorf("error parsing OUI CSV file: %w", err)
+			slog.Error("Error checking OUI watchlist", "error", err.Error())
 		}
 
-		if first {
-			// Skip CSV header
-			first = false
+		samples = append(samples, watchlistSamples(watched)...)
+	}
 
-			continue
+	filtered := filterOrganizations(records)
+
+	seen, err := filterSeen(filtered)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	infoSamples := ouiSamples(seen)
+
+	diff, err := goldenDiffSamples(metricFile, infoSamples)
+	if err != nil {
+		slog.Error("Error comparing OUI metric file with its previous generation", "error", err.Error())
+	}
+
+	samples = append(samples, diff...)
+
+	// Keep the companion samples so that the metric file can be rewritten
+	// when the set of locally seen OUIs changes
+	extraSamples = samples
+	ouiRecords = filtered
+	ouiHistories = history
+
+	if *backupOutput && metricFile != "" {
+		if err := backupMetrics(metricFile); err != nil {
+			slog.Error("Error backing up OUI metric file", "error", err.Error())
 		}
+	}
 
-		oui := strings.ToLower(entry[1])
-		organization := strings.TrimSpace(entry[2])
+	infoSamples = append(infoSamples, entriesSample(seen))
+	infoSamples = append(infoSamples, historySamples(history, seen)...)
 
-		if len(oui) != 6 {
-			slog.Error("OUI has wrong number of characters", "oui", oui)
+	if err := publishMetrics(ctx, metricFile, append(infoSamples, samples...)); err != nil {
+		return nil, nil, nil, err
+	}
 
-			continue
+	if err := writeOutputDatabase(metricFile, seen); err != nil {
+		return nil, nil, nil, err
+	}
+
+	if *organizationHashFile != "" {
+		if err := writeMetrics(*organizationHashFile, organizationHashSamples(records)); err != nil {
+			return nil, nil, nil, err
+		}
+	}
+
+	if *hwdbFile != "" {
+		if err := writeHWDB(*hwdbFile, ouiMap); err != nil {
+			return nil, nil, nil, err
 		}
+	}
 
-		oui = oui[0:2] + ":" + oui[2:4] + ":" + oui[4:6]
+	if *jsonlFile != "" {
+		if err := writeJSONL(*jsonlFile, records); err != nil {
+			return nil, nil, nil, err
+		}
+	}
 
-		if cur, exists := ouiMap[oui]; exists {
-			// Merge organization names if multiple exist for same OUI
-			ouiMap[oui] = strings.Join([]string{cur, organization}, " | ")
-		} else {
-			ouiMap[oui] = organization
+	if *yamlFile != "" {
+		if err := writeYAML(*yamlFile, records); err != nil {
+			return nil, nil, nil, err
 		}
 	}
 
-	for oui, organization := range ouiMap {
-		_, err := output.WriteString(
-			fmt.Sprintf(
-				`%s{oui="%s",organization_name="%s"} 1`,
-				*metricName,
-				strings.ReplaceAll(oui, `"`, `\"`),
-				strings.ReplaceAll(organization, `"`, `\"`),
-			) + "\n",
-		)
-		if err != nil {
-			return fmt.Errorf("error writing to temporary OUI metric file: %w", err)
+	if *aclFile != "" {
+		if err := writeACL(*aclFile, ouiMap, *aclOrganizations); err != nil {
+			return nil, nil, nil, err
 		}
 	}
 
-	if err := os.Rename(*metricFile+".tmp", *metricFile); err != nil {
-		return fmt.Errorf("error renaming OUI metric file: %w", err)
+	if *clickhouseURL != "" {
+		if err := writeClickHouse(ctx, records); err != nil {
+			slog.Error("Error loading OUI database into ClickHouse", "error", err.Error())
+		}
 	}
 
-	return nil
+	return ouiMap, watched, history, nil
+}
+
+// Shorten a duration by the --time-scale factor, so that tests can run
+// through many refreshes and retries in seconds
+func scaleDuration(d time.Duration) time.Duration {
+	return time.Duration(float64(d) / *timeScale)
 }
 
-// Calculate how many seconds to backoff for a given retry attempt
+// Calculate how long to backoff for a given retry attempt: --backoff-base,
+// doubled for every retry, plus up to half of that again as random jitter
 func backoff(retries int) time.Duration {
-	expo := int(math.Pow(2, float64(retries+2)))
+	expo := time.Duration(min(
+		float64(*backoffBase)*math.Pow(2, float64(retries)),
+		float64(*backoffMax),
+	))
 
-	half := int(expo / 2)
+	half := int64(expo / 2)
 
-	random := 0
+	random := int64(0)
 	if half >= 1 {
-		random = rand.Intn(half)
+		jitterMutex.Lock()
+		random = jitter.Int63n(half)
+		jitterMutex.Unlock()
 	}
 
-	// Cap maximum backoff time at 1 day
-	return min(
-		(time.Duration(expo+random) * time.Second),
-		(24 * time.Hour),
-	)
+	// Cap maximum backoff time at --backoff-max
+	return scaleDuration(min(
+		expo+time.Duration(random),
+		*backoffMax,
+	))
 }
 
-func main() {
+// Generate a random ID for a refresh cycle
+func newCycleID() string {
+	id := make([]byte, 8)
+	crand.Read(id)
+
+	return hex.EncodeToString(id)
+}
+
+// Run the collector, periodically refreshing the OUI database
+func collect(ctx context.Context, args []string) error {
+	if len(args) > 0 {
+		return fmt.Errorf("unexpected arguments: %s", strings.Join(args, " "))
+	}
+
 	slog.Info(
 		fmt.Sprintf("Starting %s", binName),
 		"version",
@@ -245,62 +1731,400 @@ func main() {
 		),
 	)
 
-	timerDuration, err := time.ParseDuration(*refreshInterval)
+	// The jobs of the config file run collectors of their own
+	if supervisingJobs() {
+		return runJobs(ctx)
+	}
+
+	timerDuration, schedule, err := refreshTiming()
 	if err != nil {
-		slog.Error("Error parsing refresh interval", "interval", *refreshInterval)
-		os.Exit(1)
+		return err
+	}
+
+	// Cancel in-flight downloads and shut down on SIGTERM or SIGINT
+	ctx, stop := signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	// A single cycle refreshes the other registries in the foreground.
+	// Otherwise they are refreshed by loops of their own, which are stopped
+	// while the config file is reloaded.
+	registriesErr := error(nil)
+	registries := sync.WaitGroup{}
+	stopRegistries := func() {}
+	startRegistries := func() {
+		registryCtx, cancel := context.WithCancel(ctx)
+		stopRegistries = func() {
+			cancel()
+			registries.Wait()
+		}
+
+		for _, r := range enabledRegistries() {
+			startRegistry(registryCtx, &registries, r, timerDuration)
+		}
+	}
+
+	if *oneshot {
+		registriesErr = refreshRegistries(ctx)
+	} else {
+		startRegistries()
 	}
+	defer func() { stopRegistries() }()
 
 	timer := time.NewTimer(time.Until(time.Now()))
 	defer timer.Stop()
 
+	if *captureInterface != "" {
+		if err := startCapture(*captureInterface); err != nil {
+			return err
+		}
+	}
+
+	if *sflowListen != "" {
+		if err := startSFlow(*sflowListen); err != nil {
+			return err
+		}
+	}
+
+	if *exporterListen != "" {
+		if err := startExporter(*exporterListen); err != nil {
+			return err
+		}
+	}
+
+	// A nil channel is never ready, which disables the enrichment refresh
+	enrichmentTicker := time.NewTicker(scaleDuration(*enrichmentInterval))
+	defer enrichmentTicker.Stop()
+
+	var enrichmentTick <-chan time.Time
+	if enrichmentEnabled() {
+		enrichmentTick = enrichmentTicker.C
+	}
+
+	// The enrichment sources queried over the network are polled in the
+	// background, one poll at a time, and hand their samples back to be
+	// written. A running poll is cancelled with ctx and waited for on exit.
+	polls := make(chan []enrichmentOutput, 1)
+	polling := false
+	startPoll := func(ouiMap map[string]string) {
+		if polling {
+			return
+		}
+
+		polling = true
+
+		go func() {
+			polls <- pollEnrichment(ctx, ouiMap)
+		}()
+	}
+	waitPoll := func() {
+		if polling {
+			writeEnrichment(<-polls)
+			polling = false
+		}
+	}
+	defer waitPoll()
+
+	// Dump the runtime state on SIGQUIT instead of exiting
+	quit := make(chan os.Signal, 1)
+	signal.Notify(quit, syscall.SIGQUIT)
+	defer signal.Stop(quit)
+
+	// Refresh immediately on SIGHUP
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+
 	retries := 0
+	health := collectorHealth{}
+
+	// Compare the first refresh with the OUIs of the previous run
+	previous, err := loadPreviousRefresh(*metricFile)
+	if err != nil {
+		slog.Warn("Error reading previous OUIs from metric file, not reporting changes of the first refresh", "error", err.Error())
+	}
+
+	// Keep the time of the last successful update and the validators of the
+	// downloaded registries across restarts
+	health.lastSuccess = readLastSuccess(*metricFile)
+
+	if err := loadValidators(); err != nil {
+		slog.Warn("Error loading validators, downloading the OUI database in full", "error", err.Error())
+	}
+	nextRefresh := time.Now()
+	var lastErr error
+	logger := slog.Default()
 
 	for {
-		<-timer.C
+		// Log outside of refreshes without a cycle ID
+		slog.SetDefault(logger)
+
+		select {
+		case <-enrichmentTick:
+			reportEnrichment(previous)
+			startPoll(previous)
+			rewriteMetricFile()
+
+			continue
+		case outputs := <-polls:
+			polling = false
+
+			if len(outputs) > 0 {
+				writeEnrichment(outputs)
+				rewriteMetricFile()
+			}
+
+			continue
+		case <-quit:
+			state := collectorState{
+				nextRefresh: nextRefresh,
+				retries:     retries,
+				lastError:   lastErr,
+				entries:     len(previous),
+				health:      health,
+			}
+			if err := dumpState(os.Stderr, state); err != nil {
+				slog.Error("Error dumping runtime state", "error", err.Error())
+			}
+
+			continue
+		case <-ctx.Done():
+			slog.Info("Shutting down")
+
+			return nil
+		case <-hup:
+			if *configFile != "" {
+				// Nothing else reads the flags while they are parsed again
+				stopRegistries()
+				waitPoll()
+
+				if err := reloadConfig(); err != nil {
+					slog.Error("Error reloading config file", "error", err.Error())
+				} else if timerDuration, schedule, err = refreshTiming(); err != nil {
+					slog.Error("Error reloading config file", "error", err.Error())
+				} else {
+					slog.Info("Reloaded config file", "file", *configFile)
+				}
+
+				enrichmentTick = nil
+				if enrichmentEnabled() {
+					enrichmentTick = enrichmentTicker.C
+				}
+
+				startRegistries()
+			}
+
+			slog.Info("Refreshing OUI database on SIGHUP")
+			timer.Stop()
+			forgetValidators()
+		case <-timer.C:
+		}
+
+		// Tag every log record of this refresh, so that the records of
+		// interleaved retries can be grouped
+		slog.SetDefault(logger.With("cycle", newCycleID()))
+
 		slog.Info("Updating OUI database")
 
-		filename, err := update()
+		start := time.Now()
+
+		dl, err := update(ctx)
+		health.lastFetch = dl.fetch
+
 		if err != nil {
+			removeDownload(dl)
+
+			if ctx.Err() != nil {
+				slog.Info("Shutting down, cancelled OUI database refresh")
+
+				if *oneshot {
+					return err
+				}
+
+				return nil
+			}
+
 			slog.Error(
 				"Error updating OUI database",
 				"error",
 				err.Error(),
+				"class",
+				errorClass(err, "download"),
 				"retry",
 				backoff(retries),
 			)
 
+			health.failure(errorClass(err, "download"))
+			reportHealth(health)
+			reportFailedUpdate(ctx, health)
+			reportCheckmk(len(previous), health.lastSuccess, err)
+			reportRunSummary(newRunSummary(ouiChanges{}, previous, nil, dl, time.Since(start), err, errorClass(err, "download")))
+
+			if *oneshot || *exitAfterRetries {
+				return err
+			}
+
+			lastErr = err
 			retries++
-			timer.Reset(backoff(retries))
+			nextRefresh = time.Now().Add(backoff(retries))
+			timer.Reset(time.Until(nextRefresh))
+
+			continue
+		}
+
+		if dl.notModified {
+			retries = 0
+
+			health.success()
+			reportHealth(health)
+
+			registriesUnchanged(dl)
+
+			// The metric file is still current
+			update := updateSamples(health.lastSuccess, health.failures, dl.duration)
+			if err := reportUpdate(ctx, *metricFile, append(update, freshnessSamples(dl)...)); err != nil {
+				slog.Error("Error updating OUI metric file", "error", err.Error())
+			} else {
+				recordFreshness(dl)
+			}
+
+			touchOutput(*metricFile)
+
+			if err := saveValidators(); err != nil {
+				slog.Error("Error writing validator file", "error", err.Error())
+			}
+			reportCheckmk(len(previous), health.lastSuccess, nil)
+			reportRunSummary(newRunSummary(ouiChanges{}, previous, previous, dl, time.Since(start), nil, ""))
+
+			slog.Info("OUI database not modified since previous refresh")
+
+			if *oneshot {
+				return registriesErr
+			}
+
+			nextRefresh = nextScheduledRefresh(schedule, timerDuration)
+
+			slog.Info("Next OUI database refresh time", "time", nextRefresh)
+
+			timer.Reset(time.Until(nextRefresh))
 
 			continue
 		}
 
-		if err := parse(filename); err != nil {
+		ouiMap, watched, history, err := generate(ctx, dl, previous, *metricFile, health.failures)
+		if err != nil {
+			removeDownload(dl)
+
 			slog.Error(
 				"Error parsing OUI database",
 				"error",
 				err.Error(),
+				"class",
+				errorClass(err, "parse"),
 				"retry",
 				backoff(retries),
 			)
 
+			health.failure(errorClass(err, "parse"))
+			reportHealth(health)
+			reportFailedUpdate(ctx, health)
+			reportCheckmk(len(previous), health.lastSuccess, err)
+			reportRunSummary(newRunSummary(ouiChanges{}, previous, nil, dl, time.Since(start), err, errorClass(err, "parse")))
+
+			if *oneshot {
+				return err
+			}
+
+			lastErr = err
 			retries++
-			timer.Reset(backoff(retries))
+			nextRefresh = time.Now().Add(backoff(retries))
+			timer.Reset(time.Until(nextRefresh))
 
 			continue
 		}
 
-		if err := os.Remove(filename); err != nil {
+		if err := os.Remove(dl.filename); err != nil {
 			slog.Error("Error removing temporary file", "error", err.Error())
 		}
 
+		removeRegistryDownloads(dl.registries)
+		rememberValidators(dl)
+		recordFreshness(dl)
+
+		if err := saveValidators(); err != nil {
+			slog.Error("Error writing validator file", "error", err.Error())
+		}
+
 		retries = 0
 
+		health.success()
+		reportHealth(health)
+		reportCheckmk(len(ouiMap), health.lastSuccess, nil)
+
+		changes := diffOUIs(previous, ouiMap)
+		recordChanges(changes, watched, previous, ouiMap)
+
+		if history != nil {
+			if err := writeHistory(*historyFile, history); err != nil {
+				slog.Error("Error tracking OUI history", "error", err.Error())
+			}
+		}
+		logChanges(changes, previous, ouiMap)
+
+		if *webhookURL != "" {
+			summary := newRefreshSummary(changes, previous, ouiMap, dl, time.Since(start))
+			if err := postJSON(ctx, *webhookURL, summary); err != nil {
+				slog.Error("Error sending webhook notification", "error", err.Error())
+			}
+		}
+
+		notifyChanges(ctx, changes, previous, ouiMap)
+		reportRunSummary(newRunSummary(changes, previous, ouiMap, dl, time.Since(start), nil, ""))
+
+		previous = ouiMap
+
+		reportEnrichment(previous)
+
 		slog.Info("Successfully updated OUI database")
 
-		slog.Info("Next OUI database refresh time", "time", time.Now().Add(timerDuration))
+		if *oneshot {
+			writeEnrichment(pollEnrichment(ctx, previous))
+
+			return registriesErr
+		}
+
+		startPoll(previous)
+
+		nextRefresh = nextScheduledRefresh(schedule, timerDuration)
 
-		timer.Reset(timerDuration)
+		slog.Info("Next OUI database refresh time", "time", nextRefresh)
+
+		timer.Reset(time.Until(nextRefresh))
+	}
+}
+
+func main() {
+	stopCPUProfile := func() {}
+	if *profileCPU != "" {
+		stop, err := startCPUProfile(*profileCPU)
+		if err != nil {
+			slog.Error("Error starting CPU profile", "error", err.Error())
+			os.Exit(1)
+		}
+		stopCPUProfile = stop
+	}
+
+	err := rootCmd.Run(context.Background())
+
+	stopCPUProfile()
+
+	if *profileMem != "" {
+		if err := writeMemProfile(*profileMem); err != nil {
+			slog.Error("Error writing memory profile", "error", err.Error())
+		}
+	}
+
+	if err != nil {
+		slog.Error("Error running command", "error", err.Error())
+		os.Exit(1)
 	}
 }
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/peterbourgon/ff/v4"
+	"github.com/prometheus/common/version"
+)
+
+const binDescription = "Download the IEEE OUI database and convert it to a file for the Node Exporter textfile collector"
+
+var (
+	mangenFormat *string
+)
+
+// Create the mangen subcommand
+func newMangenCommand(parent *ff.FlagSet) *ff.Command {
+	fs := ff.NewFlagSet("mangen").SetParent(parent)
+	mangenFormat = fs.StringEnumLong(
+		"format",
+		"Format of the reference: man, markdown",
+		"man",
+		"markdown",
+	)
+
+	return &ff.Command{
+		Name:      "mangen",
+		Usage:     binName + " mangen [FLAGS]",
+		ShortHelp: "Print a man page or markdown reference of all subcommands and flags",
+		Flags:     fs,
+		Exec:      mangen,
+	}
+}
+
+// Print the reference in the requested format
+func mangen(ctx context.Context, args []string) error {
+	if len(args) > 0 {
+		return fmt.Errorf("unexpected arguments: %s", strings.Join(args, " "))
+	}
+
+	commands := describeCommands(rootCmd)
+
+	if *mangenFormat == "markdown" {
+		return writeMarkdownReference(os.Stdout, commands)
+	}
+
+	return writeManPage(os.Stdout, commands)
+}
+
+// Describe the default value of a flag, if it has one worth showing
+func flagDefault(f flagInfo) string {
+	if f.def == "" || f.def == "[]" {
+		return ""
+	}
+
+	return f.def
+}
+
+// Escape text for use in roff, including a leading control character
+func roffEscape(s string) string {
+	s = strings.NewReplacer(`\`, `\e`, `-`, `\-`).Replace(s)
+	if strings.HasPrefix(s, ".") || strings.HasPrefix(s, "'") {
+		s = `\&` + s
+	}
+
+	return s
+}
+
+func writeManFlags(b *strings.Builder, flags []flagInfo) {
+	for _, f := range flags {
+		b.WriteString(".TP\n")
+		if f.value {
+			fmt.Fprintf(b, ".BI \"%s\" \" %s\"\n", roffEscape("--"+f.name), roffEscape(f.placeholder))
+		} else {
+			fmt.Fprintf(b, ".B \"%s\"\n", roffEscape("--"+f.name))
+		}
+
+		usage := f.usage
+		if def := flagDefault(f); def != "" {
+			usage += " (default: " + def + ")"
+		}
+		fmt.Fprintf(b, "%s\n", roffEscape(usage))
+	}
+}
+
+func writeManPage(w io.Writer, commands []commandInfo) error {
+	root := commands[0]
+	subcommands := commands[1:]
+
+	var b strings.Builder
+	fmt.Fprintf(&b, ".TH \"%s\" 1 \"%s\" \"%s\" \"User Commands\"\n",
+		strings.ToUpper(roffEscape(binName)), roffEscape(version.BuildDate), roffEscape(strings.TrimSpace(binName+" "+version.Version)))
+	b.WriteString(".SH NAME\n")
+	fmt.Fprintf(&b, "%s \\- %s\n", roffEscape(binName), roffEscape(binDescription))
+	b.WriteString(".SH SYNOPSIS\n")
+	fmt.Fprintf(&b, "%s\n", roffEscape(root.usage))
+	for _, cmd := range subcommands {
+		b.WriteString(".br\n")
+		fmt.Fprintf(&b, "%s\n", roffEscape(cmd.usage))
+	}
+
+	b.WriteString(".SH OPTIONS\n")
+	writeManFlags(&b, root.flags)
+
+	b.WriteString(".SH COMMANDS\n")
+	for _, cmd := range subcommands {
+		fmt.Fprintf(&b, ".SS %s\n", roffEscape(cmd.name))
+		fmt.Fprintf(&b, "%s\n", roffEscape(cmd.help))
+		writeManFlags(&b, cmd.flags)
+	}
+
+	b.WriteString(".SH ENVIRONMENT\n")
+	fmt.Fprintf(&b, "%s\n", roffEscape(fmt.Sprintf(
+		"Every flag can also be set with an environment variable named after the flag with the %s_ prefix, e.g. %s for --output-file. Values are split on spaces.",
+		strings.ToUpper(binName), envVarName("output-file"),
+	)))
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// Escape text for use in a markdown table cell
+var markdownEscaper = strings.NewReplacer(`|`, `\|`, "\n", " ")
+
+func writeMarkdownFlags(b *strings.Builder, flags []flagInfo) {
+	if len(flags) == 0 {
+		return
+	}
+
+	b.WriteString("| Flag | Environment variable | Default | Description |\n")
+	b.WriteString("| --- | --- | --- | --- |\n")
+	for _, f := range flags {
+		name := "--" + f.name
+		if f.value {
+			name += " " + f.placeholder
+		}
+
+		def := flagDefault(f)
+		if def != "" {
+			def = "`" + markdownEscaper.Replace(def) + "`"
+		}
+
+		fmt.Fprintf(b, "| `%s` | `%s` | %s | %s |\n", name, envVarName(f.name), def, markdownEscaper.Replace(f.usage))
+	}
+	b.WriteString("\n")
+}
+
+func writeMarkdownReference(w io.Writer, commands []commandInfo) error {
+	root := commands[0]
+	subcommands := commands[1:]
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "# %s\n\n", binName)
+	fmt.Fprintf(&b, "%s.\n\n", binDescription)
+	fmt.Fprintf(&b, "```\n%s\n```\n\n", root.usage)
+	b.WriteString("## Flags\n\n")
+	writeMarkdownFlags(&b, root.flags)
+
+	b.WriteString("## Commands\n\n")
+	for _, cmd := range subcommands {
+		fmt.Fprintf(&b, "### %s\n\n", cmd.name)
+		fmt.Fprintf(&b, "%s.\n\n", cmd.help)
+		fmt.Fprintf(&b, "```\n%s\n```\n\n", cmd.usage)
+		writeMarkdownFlags(&b, cmd.flags)
+	}
+
+	_, err := io.WriteString(w, strings.TrimSuffix(b.String(), "\n"))
+	return err
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"log/slog"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/prometheus/common/expfmt"
+	"github.com/prometheus/common/model"
+)
+
+// Read the metric fragments to merge into the metric file, skipping fragments
+// which are invalid or which would repeat a metric that is already written
+func readFragments(dir string, metricFile string, samples []sample) []byte {
+	filenames, err := filepath.Glob(filepath.Join(dir, "*.prom"))
+	if err != nil {
+		slog.Error("Error listing metric fragments", "error", err.Error())
+		return nil
+	}
+
+	scheme := model.LegacyValidation
+	if *nameEscaping == "utf8" {
+		scheme = model.UTF8Validation
+	}
+
+	seen := map[string]bool{}
+	for _, s := range samples {
+		seen[s.name] = true
+	}
+
+	output, _ := filepath.Abs(metricFile)
+	fragments := bytes.Buffer{}
+
+	for _, filename := range filenames {
+		// The metric file may be written into the directory it merges
+		if abs, _ := filepath.Abs(filename); abs == output {
+			continue
+		}
+
+		content, err := os.ReadFile(filename)
+		if err != nil {
+			slog.Error("Error reading metric fragment", "file", filename, "error", err.Error())
+			continue
+		}
+
+		parser := expfmt.NewTextParser(scheme)
+
+		families, err := parser.TextToMetricFamilies(bytes.NewReader(content))
+		if err != nil {
+			slog.Error("Skipping invalid metric fragment", "file", filename, "error", err.Error())
+			continue
+		}
+
+		names := slices.Sorted(maps.Keys(families))
+
+		duplicates := slices.DeleteFunc(slices.Clone(names), func(name string) bool { return !seen[name] })
+		if len(duplicates) > 0 {
+			slog.Error(
+				"Skipping metric fragment repeating metrics",
+				"file", filename,
+				"metrics", strings.Join(duplicates, ","),
+			)
+			continue
+		}
+
+		for _, name := range names {
+			seen[name] = true
+		}
+
+		fragments.Write(content)
+		if len(content) > 0 && content[len(content)-1] != '\n' {
+			fragments.WriteByte('\n')
+		}
+	}
+
+	return fragments.Bytes()
+}
+
+// Write the OUI metric file, merging in the metric fragments if enabled
+func writeOUIMetrics(metricFile string, samples []sample) error {
+	if *mergeDir == "" {
+		return writeMetrics(metricFile, samples)
+	}
+
+	return writeMetricsWithFragments(metricFile, samples, readFragments(*mergeDir, metricFile, samples))
+}
+
+// Rewrite the metric file between refreshes of the OUI database, if the set
+// of locally seen OUIs or the merged metric fragments can change and the OUI
+// database is loaded. Only the metric file and the samples served by the
+// exporter are updated: copies and pushes follow the refreshes.
+func rewriteMetricFile() {
+	if (!seenOnly() && *mergeDir == "") || ouiRecords == nil {
+		return
+	}
+
+	seen, err := filterSeen(ouiRecords)
+	if err != nil {
+		slog.Error("Error filtering OUI metric file, keeping the previous one", "error", err.Error())
+
+		return
+	}
+
+	samples := append(recordSamples(seen), extraSamples...)
+
+	if err := writeMetricFile(*metricFile, samples); err != nil {
+		slog.Error("Error writing OUI metric file", "error", err.Error())
+	}
+
+	exportSamples(samples)
+
+	if err := writeOutputDatabase(*metricFile, seen); err != nil {
+		slog.Error("Error writing OUI output file", "error", err.Error())
+	}
+}
+
+// Write the OUI metric file, unless it is only served by the exporter or is
+// written in another format
+func writeMetricFile(metricFile string, samples []sample) error {
+	if metricFile == "" || *outputFormat != "prom" {
+		return nil
+	}
+
+	return writeOUIMetrics(metricFile, samples)
+}
+
+// Write the OUI metric file and its copies, then serve the samples from the
+// exporter and push them to the Pushgateway
+func publishMetrics(ctx context.Context, metricFile string, samples []sample) error {
+	if err := writeMetricFile(metricFile, samples); err != nil {
+		return err
+	}
+
+	writeExtraOutputs(samples)
+	exportSamples(samples)
+	pushSamples(ctx, samples)
+
+	return nil
+}
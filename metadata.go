@@ -0,0 +1,111 @@
+package main
+
+import (
+	"strings"
+)
+
+// HELP texts of the companion metrics, keyed by their suffix
+var companionHelp = map[string]string{
+	"collector_build_info":                     "Version of the collector which produced the metrics",
+	"last_successful_update_timestamp_seconds": "Time of the last successful refresh of the OUI database",
+	"entries":                                  "Number of OUIs in the metric file",
+	"update_failures_total":                    "Number of failed refreshes of the OUI database since startup",
+	"download_duration_seconds":                "Time taken to download the registries",
+	"registry_last_success_timestamp_seconds":  "Time of the last successful refresh of an IEEE registry",
+	"registry_failures_total":                  "Number of failed refreshes of an IEEE registry since startup",
+	"parse_errors_total":                       "Number of malformed rows of the OUI database found by lenient parsing since startup",
+	"source_last_modified_timestamp_seconds":   "Time the OUI database was last modified upstream",
+	"source_changed":                           "Whether the OUI database changed since the previous refresh",
+	"source_unchanged_fetches":                 "Number of consecutive refreshes which downloaded an unchanged OUI database",
+	"renamed_total":                            "Number of OUIs whose organization changed between refreshes",
+	"entries_added_total":                      "Number of OUIs added to the OUI database between refreshes",
+	"entries_removed_total":                    "Number of OUIs removed from the OUI database between refreshes",
+	"first_seen_timestamp_seconds":             "Time an OUI was first seen in the OUI database",
+	"organization_changed_timestamp_seconds":   "Time the organization of an OUI last changed",
+	"watchlist_changed":                        "Watched OUI which was added, removed or renamed by the last refresh",
+	"watchlist_changes_total":                  "Number of changes of watched OUIs between refreshes",
+	"output_series_changes":                    "Number of series added, removed or changed since the previous generation of the metric file",
+	"output_series_previous":                   "Number of series in the previous generation of the metric file",
+	"organization_blocks":                      "Number of OUI blocks assigned to an organization",
+	"organization_hash_info":                   "Organization name of an organization hash",
+	"collector_failures_total":                 "Number of failed refreshes since startup",
+	"collector_consecutive_failures":           "Number of failed refreshes since the last successful refresh",
+	"collector_last_success_timestamp_seconds": "Time of the last successful refresh",
+	"collector_last_failure_timestamp_seconds": "Time of the last failed refresh",
+	"collector_errors_total":                   "Number of failed refreshes by class of error",
+	"collector_last_error_info":                "Class of the most recent error",
+	"collector_output_errors_total":            "Number of failed writes to an extra output file",
+	"collector_http_status_code":               "HTTP status code of the most recent download",
+	"collector_http_response_bytes":            "Size of the response body of the most recent download",
+	"collector_http_redirects":                 "Number of redirects followed by the most recent download",
+	"collector_http_dns_seconds":               "Time the most recent download spent resolving host names",
+	"collector_http_connect_seconds":           "Time the most recent download spent establishing TCP connections",
+	"collector_http_tls_handshake_seconds":     "Time the most recent download spent in TLS handshakes",
+}
+
+// Find the HELP text of a metric, or an empty string if it has none
+func metricHelpText(name string) string {
+	switch name {
+	case *metricName:
+		return *metricHelp
+	case *neighborMetricName:
+		return "Vendor of a MAC address in the neighbor table"
+	case *dhcpMetricName:
+		return "Vendor of the MAC address of a DHCP lease"
+	case *captureMetricName:
+		return "Number of captured devices by vendor"
+	case *snmpMetricName:
+		return "Vendor of a MAC address in a switch forwarding table"
+	case *wifiMetricName:
+		return "Number of wireless clients by vendor"
+	case *sflowMetricPrefix + "_frames_total":
+		return "Estimated number of frames by source MAC address vendor"
+	case *sflowMetricPrefix + "_bytes_total":
+		return "Estimated number of bytes by source MAC address vendor"
+	case *sflowMetricPrefix + "_devices":
+		return "Number of distinct source MAC addresses by vendor"
+	case *nicMetricName:
+		return "Vendor of a network interface"
+	case *ethertypeMetricName:
+		return "Protocol of an EtherType"
+	case *bluetoothMetricName:
+		return "Organization a Bluetooth company identifier is assigned to"
+	case *usbMetricName:
+		return "Vendor of a USB vendor ID"
+	case *usbProductMetric:
+		return "Product of a USB product ID"
+	case *pciMetricName:
+		return "Vendor of a PCI vendor ID"
+	case *enterpriseMetricName:
+		return "Organization an SNMP private enterprise number is assigned to"
+	case "mac_observed_addresses":
+		return "Number of distinct observed MAC addresses"
+	case "mac_locally_administered_ratio":
+		return "Share of the observed MAC addresses which are locally administered"
+	}
+
+	if suffix, ok := strings.CutPrefix(name, strings.TrimSuffix(*metricName, "_info")+"_"); ok {
+		return companionHelp[suffix]
+	}
+
+	return ""
+}
+
+// Find the type of a metric from its name, as counters always end in _total
+func metricType(name string) string {
+	if strings.HasSuffix(name, "_total") {
+		return "counter"
+	}
+
+	return "gauge"
+}
+
+// Find the name of the metric family of a sample. In OpenMetrics, the _total
+// suffix of counters isn't part of the family name.
+func familyName(name string) string {
+	if *openMetrics && metricType(name) == "counter" {
+		return strings.TrimSuffix(name, "_total")
+	}
+
+	return name
+}
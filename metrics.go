@@ -0,0 +1,508 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/common/version"
+)
+
+// A label on a Prometheus sample
+type label struct {
+	name  string
+	value string
+}
+
+// A single sample in the Prometheus text exposition format
+type sample struct {
+	name   string
+	labels []label
+	value  float64
+	// The labels already have their output names, e.g. from
+	// --metric-template, so they are not renamed
+	outputNames bool
+	// The sample describes a single OUI, e.g. its info series, rather than
+	// the collector
+	perOUI bool
+}
+
+// Escapes backslashes, double quotes and line feeds in quoted strings
+var quotedEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// Escape a string for use between double quotes
+func escapeQuoted(s string) string {
+	return quotedEscaper.Replace(s)
+}
+
+// Format the sample as a line of the Prometheus text exposition format
+//
+// With --name-escaping utf8, names which are not valid legacy names are
+// quoted, and the metric name moves inside the braces as Prometheus 3 expects
+func (s sample) String() string {
+	var b strings.Builder
+
+	quoteName := *nameEscaping == "utf8" && !model.LegacyValidation.IsValidMetricName(s.name)
+
+	if !quoteName {
+		b.WriteString(s.name)
+	}
+
+	if len(s.labels) > 0 || quoteName {
+		b.WriteString("{")
+
+		if quoteName {
+			b.WriteString(`"`)
+			b.WriteString(escapeQuoted(s.name))
+			b.WriteString(`"`)
+		}
+
+		for i, l := range s.labels {
+			if i > 0 || quoteName {
+				b.WriteString(",")
+			}
+
+			if *nameEscaping == "utf8" && !model.LegacyValidation.IsValidLabelName(l.name) {
+				b.WriteString(`"`)
+				b.WriteString(escapeQuoted(l.name))
+				b.WriteString(`"`)
+			} else {
+				b.WriteString(l.name)
+			}
+
+			b.WriteString(`="`)
+			b.WriteString(escapeQuoted(l.value))
+			b.WriteString(`"`)
+		}
+
+		b.WriteString("}")
+	}
+
+	b.WriteString(" ")
+	b.WriteString(strconv.FormatFloat(s.value, 'f', -1, 64))
+
+	return b.String()
+}
+
+// Format the name of a metric family for a HELP or TYPE comment line
+func metadataName(name string) string {
+	if *nameEscaping == "utf8" && !model.LegacyValidation.IsValidMetricName(name) {
+		return `"` + escapeQuoted(name) + `"`
+	}
+
+	return name
+}
+
+// Format a HELP comment line for a metric. OpenMetrics escapes double quotes
+// in HELP texts as well.
+func helpLine(name string, help string) string {
+	if *openMetrics {
+		help = escapeQuoted(help)
+	} else {
+		help = strings.NewReplacer(`\`, `\\`, "\n", `\n`).Replace(help)
+	}
+
+	return "# HELP " + metadataName(familyName(name)) + " " + help
+}
+
+// Format a TYPE comment line for a metric
+func typeLine(name string) string {
+	return "# TYPE " + metadataName(familyName(name)) + " " + metricType(name)
+}
+
+// Build the name of a companion metric from the configured metric name
+func metricNameWithSuffix(suffix string) string {
+	return strings.TrimSuffix(*metricName, "_info") + "_" + suffix
+}
+
+// Build a short stable hash of an organization name
+func organizationHash(organization string) string {
+	sum := sha256.Sum256([]byte(organization))
+
+	return hex.EncodeToString(sum[:6])
+}
+
+// Build the organization label of an info sample, hashing the organization
+// name if enabled
+func organizationLabel(organization string) label {
+	if *organizationHashFile != "" {
+		return label{name: "organization_hash", value: organizationHash(organization)}
+	}
+
+	return label{name: "organization_name", value: organization}
+}
+
+// Build the labels telling the registry and the prefix of 28-bit and 36-bit
+// assignments apart, when registries other than MA-L are downloaded
+func registryLabels(r ouiRecord) []label {
+	if len(extraRegistries()) == 0 {
+		return nil
+	}
+
+	labels := []label{{name: "registry", value: r.registry}}
+
+	if r.prefix != "" {
+		labels = append(labels, label{name: "prefix", value: r.prefix})
+	}
+
+	return labels
+}
+
+// Build the info samples for a set of OUI records
+func ouiSamples(records []ouiRecord) []sample {
+	samples := make([]sample, 0, len(records))
+
+	for _, group := range groupRecords(records) {
+		if *duplicatePolicy == "records" {
+			// Keep one series per record, told apart by their position
+			for i, r := range group {
+				labels := append([]label{
+					{name: "oui", value: r.oui},
+					organizationLabel(r.organization),
+				}, registryLabels(r)...)
+				labels = append(labels, label{name: "index", value: strconv.Itoa(i)})
+
+				if *assignmentLabel {
+					labels = append(labels, label{name: "assignment", value: r.assignment})
+				}
+
+				samples = append(samples, sample{
+					name:   *metricName,
+					labels: labels,
+					value:  1,
+					perOUI: true,
+				})
+			}
+
+			continue
+		}
+
+		labels := append([]label{
+			{name: "oui", value: group[0].oui},
+			organizationLabel(joinOrganizations(group)),
+		}, registryLabels(group[0])...)
+
+		if *mergedLabel {
+			labels = append(labels, label{name: "merged", value: strconv.FormatBool(len(group) > 1)})
+		}
+
+		if *assignmentLabel {
+			labels = append(labels, label{name: "assignment", value: group[0].assignment})
+		}
+
+		samples = append(samples, sample{
+			name:   *metricName,
+			labels: labels,
+			value:  1,
+			perOUI: true,
+		})
+	}
+
+	return applyMetricTemplate(samples)
+}
+
+// Build a sample identifying the collector build which produced the metrics
+func buildInfoSample() sample {
+	return sample{
+		name: metricNameWithSuffix("collector_build_info"),
+		labels: []label{
+			{name: "version", value: version.Version},
+			{name: "revision", value: version.Revision},
+			{name: "goversion", value: version.GoVersion},
+		},
+		value: 1,
+	}
+}
+
+// Build samples counting the OUI blocks held by each organization
+func organizationBlockSamples(ouiMap map[string]string) []sample {
+	blocks := map[string]int{}
+
+	for _, organization := range ouiMap {
+		blocks[organization]++
+	}
+
+	samples := make([]sample, 0, len(blocks))
+
+	for organization, count := range blocks {
+		samples = append(samples, sample{
+			name: metricNameWithSuffix("organization_blocks"),
+			labels: []label{
+				{name: "organization_name", value: organization},
+			},
+			value: float64(count),
+		})
+	}
+
+	return samples
+}
+
+// Build samples mapping each organization hash to its organization name
+func organizationHashSamples(records []ouiRecord) []sample {
+	organizations := map[string]bool{}
+
+	for _, group := range groupRecords(records) {
+		if *duplicatePolicy == "records" {
+			for _, r := range group {
+				organizations[r.organization] = true
+			}
+		} else {
+			organizations[joinOrganizations(group)] = true
+		}
+	}
+
+	samples := make([]sample, 0, len(organizations))
+
+	for organization := range organizations {
+		samples = append(samples, sample{
+			name: metricNameWithSuffix("organization_hash_info"),
+			labels: []label{
+				{name: "organization_hash", value: organizationHash(organization)},
+				{name: "organization_name", value: organization},
+			},
+			value: 1,
+		})
+	}
+
+	return samples
+}
+
+// Keep the current generation of a metric file as a .bak file. The backup is
+// a hard link, so it keeps the old contents once the metric file is replaced.
+func backupMetrics(metricFile string) error {
+	if _, err := os.Stat(metricFile); errors.Is(err, fs.ErrNotExist) || isFIFO(metricFile) {
+		return nil
+	}
+
+	if err := os.Remove(metricFile + ".bak"); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("error removing old metric backup file: %w", err)
+	}
+
+	if err := os.Link(metricFile, metricFile+".bak"); err != nil {
+		return fmt.Errorf("error creating metric backup file: %w", err)
+	}
+
+	return nil
+}
+
+// Check whether a path is a named pipe, which is streamed into instead of
+// being replaced
+func isFIFO(filename string) bool {
+	info, err := os.Stat(filename)
+	return err == nil && info.Mode()&fs.ModeNamedPipe != 0
+}
+
+// Write samples in the text exposition format, returning the number of lines
+// written. The samples of each metric are grouped after HELP and TYPE lines,
+// in the order the metrics first appear, and carry the constant labels.
+func writeSamples(w *bufio.Writer, samples []sample) (int, error) {
+	lines := 0
+	static := staticLabels()
+	names := []string{}
+	families := map[string][]sample{}
+
+	for _, s := range samples {
+		if _, ok := families[s.name]; !ok {
+			names = append(names, s.name)
+		}
+
+		families[s.name] = append(families[s.name], s)
+	}
+
+	for _, name := range names {
+		metadata := []string{typeLine(name)}
+		if help := metricHelpText(name); help != "" {
+			metadata = []string{helpLine(name, help), typeLine(name)}
+		}
+
+		for _, line := range metadata {
+			if _, err := w.WriteString(line + "\n"); err != nil {
+				return lines, err
+			}
+
+			lines++
+		}
+
+		for _, s := range families[name] {
+			s.labels = sampleOutputLabels(s, static)
+
+			if _, err := w.WriteString(s.String() + "\n"); err != nil {
+				return lines, err
+			}
+
+			lines++
+		}
+	}
+
+	return lines, nil
+}
+
+// Write the end of an OpenMetrics exposition, if enabled, returning the number
+// of lines written
+func writeEOF(w *bufio.Writer) (int, error) {
+	if !*openMetrics {
+		return 0, nil
+	}
+
+	if _, err := w.WriteString("# EOF\n"); err != nil {
+		return 0, err
+	}
+
+	return 1, nil
+}
+
+// Atomically write samples to a metric file, or stream them into it if it is
+// a named pipe
+func writeMetrics(metricFile string, samples []sample) error {
+	return writeMetricsWithFragments(metricFile, samples, nil)
+}
+
+// Write samples to a metric file followed by already validated metric
+// fragments in the text exposition format
+func writeMetricsWithFragments(metricFile string, samples []sample, fragments []byte) error {
+	fifo := isFIFO(metricFile)
+	target := "temporary metric file"
+
+	var output *os.File
+	var err error
+
+	if fifo {
+		target = "metric pipe"
+		output, err = os.OpenFile(metricFile, os.O_WRONLY, 0)
+	} else {
+		output, err = createTemp(metricFile)
+	}
+
+	if err != nil {
+		return withClass("write", fmt.Errorf("error opening %s: %w", target, err))
+	}
+	defer output.Close()
+
+	hash := sha256.New()
+	w := bufio.NewWriter(io.MultiWriter(output, hash))
+
+	lines, err := writeSamples(w, samples)
+	if err != nil {
+		return withClass("write", fmt.Errorf("error writing to %s: %w", target, err))
+	}
+
+	if _, err := w.Write(fragments); err != nil {
+		return withClass("write", fmt.Errorf("error writing to %s: %w", target, err))
+	}
+
+	lines += bytes.Count(fragments, []byte("\n"))
+
+	eof, err := writeEOF(w)
+	if err != nil {
+		return withClass("write", fmt.Errorf("error writing to %s: %w", target, err))
+	}
+
+	lines += eof
+
+	if err := w.Flush(); err != nil {
+		return withClass("write", fmt.Errorf("error writing to %s: %w", target, err))
+	}
+
+	if fifo {
+		return nil
+	}
+
+	// Keep the metric file if its content is unchanged, so that readers
+	// don't reload it for nothing, but touch it to show that it is current.
+	// It still gets the configured permissions, which may have changed.
+	sum := hex.EncodeToString(hash.Sum(nil))
+	if existing, err := fileSHA256(metricFile); err == nil && existing == sum {
+		slog.Debug("Metric file unchanged, not rewriting", "file", metricFile)
+		output.Close()
+		os.Remove(output.Name())
+
+		if err := keepMetricFile(metricFile); err != nil {
+			return err
+		}
+
+		touchOutput(metricFile)
+
+		return nil
+	}
+
+	if err := replaceWithTemp(output, metricFile); err != nil {
+		return err
+	}
+
+	if *verifyOutput {
+		return verifyMetrics(metricFile, lines, sum)
+	}
+
+	return nil
+}
+
+// Apply the configured permissions to a metric file which is kept, as its
+// content is unchanged
+func keepMetricFile(metricFile string) error {
+	f, err := os.Open(metricFile)
+	if err != nil {
+		return withClass("write", fmt.Errorf("error opening metric file: %w", err))
+	}
+	defer f.Close()
+
+	return applyOutputPermissions(f, "metric file")
+}
+
+// Calculate the SHA-256 of a file
+func fileSHA256(filename string) (string, error) {
+	input, err := os.Open(filename)
+	if err != nil {
+		return "", err
+	}
+	defer input.Close()
+
+	hash := sha256.New()
+	if _, err := io.Copy(hash, input); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// Re-read a metric file after it has been replaced and check that it holds
+// what was written, to catch corruption by the filesystem
+func verifyMetrics(metricFile string, lines int, sha256sum string) error {
+	input, err := os.Open(metricFile)
+	if err != nil {
+		return withClass("verification", fmt.Errorf("error opening metric file for verification: %w", err))
+	}
+	defer input.Close()
+
+	hash := sha256.New()
+	scanner := bufio.NewScanner(io.TeeReader(input, hash))
+	scanner.Buffer(nil, 1024*1024)
+	found := 0
+
+	for scanner.Scan() {
+		found++
+	}
+
+	if err := scanner.Err(); err != nil {
+		return withClass("verification", fmt.Errorf("error reading metric file for verification: %w", err))
+	}
+
+	if found != lines {
+		return withClass("verification", fmt.Errorf("metric file %s has %d lines, expected %d", metricFile, found, lines))
+	}
+
+	if sum := hex.EncodeToString(hash.Sum(nil)); sum != sha256sum {
+		return withClass("verification", fmt.Errorf("metric file %s has sha256 %s, expected %s", metricFile, sum, sha256sum))
+	}
+
+	return nil
+}
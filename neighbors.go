@@ -0,0 +1,68 @@
+package main
+
+import (
+	"log/slog"
+	"net"
+	"slices"
+	"strings"
+)
+
+// An entry in the local ARP/ND neighbor table
+type neighbor struct {
+	ip    net.IP
+	mac   net.HardwareAddr
+	iface string
+}
+
+// Build vendor samples for the MAC addresses in the neighbor table
+func neighborSamples(ouiMap map[string]string) ([]sample, error) {
+	neighbors, err := readNeighbors()
+	if err != nil {
+		return nil, err
+	}
+
+	samples := []sample{}
+	seen := map[string]bool{}
+	interfaces := map[string][]net.HardwareAddr{}
+	seenOnInterface := map[string]bool{}
+
+	for _, n := range neighbors {
+		if !seenOnInterface[n.iface+n.mac.String()] {
+			seenOnInterface[n.iface+n.mac.String()] = true
+			interfaces[n.iface] = append(interfaces[n.iface], n.mac)
+		}
+
+		// A MAC address can have several IPv4 and IPv6 neighbor entries
+		if seen[n.mac.String()] {
+			continue
+		}
+
+		seen[n.mac.String()] = true
+
+		samples = append(samples, vendorSample(*neighborMetricName, ouiMap, n.mac))
+	}
+
+	slices.SortFunc(samples, func(a, b sample) int {
+		return strings.Compare(a.labels[0].value, b.labels[0].value)
+	})
+
+	return append(samples, randomizationSamples("neighbors", "interface", interfaces)...), nil
+}
+
+// Update the neighbor metric file, if enabled and the OUI database is loaded
+func reportNeighbors(ouiMap map[string]string) {
+	if *neighborFile == "" || ouiMap == nil {
+		return
+	}
+
+	samples, err := neighborSamples(ouiMap)
+	if err != nil {
+		slog.Error("Error reading neighbor table", "error", err.Error())
+
+		return
+	}
+
+	if err := writeMetrics(*neighborFile, samples); err != nil {
+		slog.Error("Error writing neighbor metric file", "error", err.Error())
+	}
+}
@@ -0,0 +1,87 @@
+//go:build linux
+
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"syscall"
+)
+
+const (
+	// Size of struct ndmsg
+	ndmsgLen = 12
+
+	// Neighbor attribute types
+	ndaDst    = 1
+	ndaLLAddr = 2
+
+	// Neighbor states which don't have a usable link layer address
+	nudUnusable = 0x01 | 0x20 | 0x40 // NUD_INCOMPLETE | NUD_FAILED | NUD_NOARP
+)
+
+// Read the IPv4 ARP and IPv6 ND neighbor tables over netlink
+func readNeighbors() ([]neighbor, error) {
+	data, err := syscall.NetlinkRIB(syscall.RTM_GETNEIGH, syscall.AF_UNSPEC)
+	if err != nil {
+		return nil, fmt.Errorf("error dumping neighbor table: %w", err)
+	}
+
+	msgs, err := syscall.ParseNetlinkMessage(data)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing neighbor table: %w", err)
+	}
+
+	neighbors := []neighbor{}
+
+	for _, m := range msgs {
+		if m.Header.Type != syscall.RTM_NEWNEIGH || len(m.Data) < ndmsgLen {
+			continue
+		}
+
+		ifindex := int(binary.NativeEndian.Uint32(m.Data[4:8]))
+		state := binary.NativeEndian.Uint16(m.Data[8:10])
+
+		if state&nudUnusable != 0 {
+			continue
+		}
+
+		n := neighbor{}
+
+		// Walk the route attributes following the ndmsg header
+		attrs := m.Data[ndmsgLen:]
+		for len(attrs) >= syscall.SizeofRtAttr {
+			attrLen := int(binary.NativeEndian.Uint16(attrs[0:2]))
+			attrType := binary.NativeEndian.Uint16(attrs[2:4])
+
+			if attrLen < syscall.SizeofRtAttr || attrLen > len(attrs) {
+				break
+			}
+
+			value := attrs[syscall.SizeofRtAttr:attrLen]
+
+			switch attrType {
+			case ndaDst:
+				n.ip = net.IP(value)
+			case ndaLLAddr:
+				n.mac = net.HardwareAddr(value)
+			}
+
+			// Attributes are aligned to 4 bytes
+			attrs = attrs[min((attrLen+3)&^3, len(attrs)):]
+		}
+
+		if len(n.mac) != 6 || n.mac.String() == "00:00:00:00:00:00" {
+			continue
+		}
+
+		if iface, err := net.InterfaceByIndex(ifindex); err == nil {
+			n.iface = iface.Name
+		}
+
+		neighbors = append(neighbors, n)
+	}
+
+	return neighbors, nil
+}
@@ -0,0 +1,12 @@
+//go:build !linux
+
+package main
+
+import (
+	"errors"
+)
+
+// Reading the neighbor table is only implemented on Linux
+func readNeighbors() ([]neighbor, error) {
+	return nil, errors.New("reading the neighbor table is not supported on this platform")
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"net"
+	"os"
+	"path/filepath"
+	"runtime"
+)
+
+// Check whether a network interface is backed by a physical device. Outside
+// of Linux, every interface with a hardware address is assumed to be.
+func isPhysical(iface net.Interface) bool {
+	if iface.Flags&net.FlagLoopback != 0 || len(iface.HardwareAddr) != 6 {
+		return false
+	}
+
+	if runtime.GOOS != "linux" {
+		return true
+	}
+
+	_, err := os.Stat(filepath.Join("/sys/class/net", iface.Name, "device"))
+
+	return err == nil
+}
+
+// Build vendor samples for the physical network interfaces of this host
+func nicSamples(ouiMap map[string]string) ([]sample, error) {
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return nil, fmt.Errorf("error listing network interfaces: %w", err)
+	}
+
+	samples := []sample{}
+
+	for _, iface := range ifaces {
+		if !isPhysical(iface) {
+			continue
+		}
+
+		samples = append(samples, vendorSample(
+			*nicMetricName,
+			ouiMap,
+			iface.HardwareAddr,
+			label{name: "device", value: iface.Name},
+		))
+	}
+
+	return samples, nil
+}
+
+// Update the network interface metric file, if enabled and the OUI database
+// is loaded
+func reportNICs(ouiMap map[string]string) {
+	if *nicFile == "" || ouiMap == nil {
+		return
+	}
+
+	samples, err := nicSamples(ouiMap)
+	if err != nil {
+		slog.Error("Error reading network interfaces", "error", err.Error())
+
+		return
+	}
+
+	if err := writeMetrics(*nicFile, samples); err != nil {
+		slog.Error("Error writing network interface metric file", "error", err.Error())
+	}
+}
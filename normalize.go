@@ -0,0 +1,136 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Rewrite the spelling variants of an organization name to a common form.
+// Whitespace is collapsed and punctuation is trimmed from the end of each
+// word, so that "Apple, Inc." and "Apple  Inc" both become "Apple Inc".
+func normalizeOrganization(organization string) string {
+	words := strings.Fields(organization)
+
+	for i, word := range words {
+		words[i] = strings.TrimRight(word, ".,;:")
+	}
+
+	// Drop the words which were only punctuation
+	words = slices.DeleteFunc(words, func(word string) bool { return word == "" })
+
+	return strings.Join(words, " ")
+}
+
+// Capitalize the first letter of each word of an organization name and lower
+// case the rest
+func titleCase(organization string) string {
+	words := strings.Fields(organization)
+
+	for i, word := range words {
+		first, size := utf8.DecodeRuneInString(word)
+		words[i] = string(unicode.ToUpper(first)) + strings.ToLower(word[size:])
+	}
+
+	return strings.Join(words, " ")
+}
+
+// Load an alias file, which contains one "variant = organization" rule per
+// line. Variants match case-insensitively after normalization, if enabled.
+func loadAliases(filename string) (map[string]string, error) {
+	aliases := map[string]string{}
+
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, fmt.Errorf("error opening alias file: %w", err)
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	line := 0
+
+	for scanner.Scan() {
+		line++
+
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+
+		variant, organization, ok := strings.Cut(text, "=")
+		variant = strings.TrimSpace(variant)
+		organization = strings.TrimSpace(organization)
+
+		if !ok || variant == "" || organization == "" {
+			return nil, fmt.Errorf("error parsing alias file: line %d is not a \"variant = organization\" rule", line)
+		}
+
+		aliases[aliasKey(variant)] = organization
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("error reading alias file: %w", err)
+	}
+
+	return aliases, nil
+}
+
+// The key under which an organization name is looked up in the aliases
+func aliasKey(organization string) string {
+	if *normalizeOrganizations {
+		organization = normalizeOrganization(organization)
+	}
+
+	return strings.ToLower(organization)
+}
+
+// Rewrite an organization name as configured: normalize it, replace it by its
+// alias and change its case
+func rewriteOrganization(organization string, aliases map[string]string) string {
+	if *normalizeOrganizations {
+		organization = normalizeOrganization(organization)
+	}
+
+	if alias, ok := aliases[aliasKey(organization)]; ok {
+		organization = alias
+	}
+
+	switch *organizationCase {
+	case "lower":
+		return strings.ToLower(organization)
+	case "upper":
+		return strings.ToUpper(organization)
+	case "title":
+		return titleCase(organization)
+	}
+
+	return organization
+}
+
+// Rewrite the organization names of OUI records, if enabled
+func rewriteOrganizations(records []ouiRecord) error {
+	if !*normalizeOrganizations && *aliasFile == "" && *organizationCase == "preserve" {
+		return nil
+	}
+
+	aliases := map[string]string{}
+
+	if *aliasFile != "" {
+		var err error
+
+		aliases, err = loadAliases(*aliasFile)
+		if err != nil {
+			return err
+		}
+	}
+
+	for i := range records {
+		records[i].organization = rewriteOrganization(records[i].organization, aliases)
+	}
+
+	return nil
+}
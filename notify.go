@@ -0,0 +1,108 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/smtp"
+	"strings"
+	"time"
+)
+
+// Maximum number of OUIs of each kind of change listed in a notification
+const notifyMaxListed = 50
+
+// Format a human readable report of the changes in a refresh
+func formatChanges(changes ouiChanges, previous map[string]string, current map[string]string) string {
+	var b strings.Builder
+
+	fmt.Fprintf(
+		&b,
+		"OUI database refreshed: %d OUIs, %d added, %d removed, %d renamed\n",
+		len(current),
+		len(changes.added),
+		len(changes.removed),
+		len(changes.renamed),
+	)
+
+	list := func(title string, ouis []string, describe func(oui string) string) {
+		if len(ouis) == 0 {
+			return
+		}
+
+		fmt.Fprintf(&b, "\n%s:\n", title)
+
+		for i, oui := range ouis {
+			if i == notifyMaxListed {
+				fmt.Fprintf(&b, "  ... and %d more\n", len(ouis)-notifyMaxListed)
+
+				break
+			}
+
+			fmt.Fprintf(&b, "  %s %s\n", oui, describe(oui))
+		}
+	}
+
+	list("Added", changes.added, func(oui string) string {
+		return current[oui]
+	})
+	list("Removed", changes.removed, func(oui string) string {
+		return previous[oui]
+	})
+	list("Renamed", changes.renamed, func(oui string) string {
+		return previous[oui] + " -> " + current[oui]
+	})
+
+	return b.String()
+}
+
+// Send a message to a Slack incoming webhook
+func postSlack(ctx context.Context, url string, text string) error {
+	return postJSON(ctx, url, map[string]string{"text": text})
+}
+
+// Send a message by email
+func sendEmail(addr string, from string, to []string, subject string, text string) error {
+	var auth smtp.Auth
+	if *smtpUsername != "" {
+		host, _, _ := strings.Cut(addr, ":")
+		auth = smtp.PlainAuth("", *smtpUsername, *smtpPassword, host)
+	}
+
+	msg := "From: " + from + "\r\n" +
+		"To: " + strings.Join(to, ", ") + "\r\n" +
+		"Subject: " + subject + "\r\n" +
+		"Date: " + time.Now().Format(time.RFC1123Z) + "\r\n" +
+		"Content-Type: text/plain; charset=utf-8\r\n" +
+		"\r\n" +
+		strings.ReplaceAll(text, "\n", "\r\n")
+
+	if err := smtp.SendMail(addr, auth, from, to, []byte(msg)); err != nil {
+		return fmt.Errorf("error sending email: %w", err)
+	}
+
+	return nil
+}
+
+// Report the changes in a refresh to Slack and email, if enabled. Nothing is
+// sent when no OUIs changed.
+func notifyChanges(ctx context.Context, changes ouiChanges, previous map[string]string, current map[string]string) {
+	if len(changes.added)+len(changes.removed)+len(changes.renamed) == 0 {
+		return
+	}
+
+	text := formatChanges(changes, previous, current)
+
+	if *slackURL != "" {
+		if err := postSlack(ctx, *slackURL, text); err != nil {
+			slog.Error("Error sending Slack notification", "error", err.Error())
+		}
+	}
+
+	if *smtpAddr != "" && len(*smtpTo) > 0 {
+		err := sendEmail(*smtpAddr, *smtpFrom, *smtpTo, "OUI database changes", text)
+		if err != nil {
+			slog.Error("Error sending email notification", "error", err.Error())
+		}
+	}
+}
@@ -0,0 +1,410 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"maps"
+	"os"
+	"os/user"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/adaricorp/oui-textfile-collector/pkg/ouidb"
+)
+
+// The permissions of output files, from --output-mode
+func outputFileMode() os.FileMode {
+	// The mode was checked by validateFlags
+	mode, _ := strconv.ParseUint(*outputMode, 8, 32)
+
+	return os.FileMode(mode)
+}
+
+// Find the user and group IDs of --output-owner and --output-group, or -1 if
+// they aren't set, which leaves them unchanged
+func outputOwnership() (int, int, error) {
+	uid, gid := -1, -1
+
+	if *outputOwner != "" {
+		u, err := user.Lookup(*outputOwner)
+		if err != nil {
+			if byID, idErr := user.LookupId(*outputOwner); idErr == nil {
+				u, err = byID, nil
+			}
+		}
+
+		if err != nil {
+			return 0, 0, fmt.Errorf("invalid --output-owner %q: %w", *outputOwner, err)
+		}
+
+		uid, _ = strconv.Atoi(u.Uid)
+	}
+
+	if *outputGroup != "" {
+		g, err := user.LookupGroup(*outputGroup)
+		if err != nil {
+			if byID, idErr := user.LookupGroupId(*outputGroup); idErr == nil {
+				g, err = byID, nil
+			}
+		}
+
+		if err != nil {
+			return 0, 0, fmt.Errorf("invalid --output-group %q: %w", *outputGroup, err)
+		}
+
+		gid, _ = strconv.Atoi(g.Gid)
+	}
+
+	return uid, gid, nil
+}
+
+// Create the temporary file which replaces an output file once it has been
+// written. It is created in the same directory, as renaming is only atomic
+// within a filesystem.
+func createTemp(filename string) (*os.File, error) {
+	return os.OpenFile(filename+".tmp", os.O_WRONLY|os.O_CREATE|os.O_TRUNC, outputFileMode())
+}
+
+// Apply the configured permissions, owner and group to an output file
+func applyOutputPermissions(output *os.File, target string) error {
+	if err := output.Chmod(outputFileMode()); err != nil {
+		return withClass("write", fmt.Errorf("error setting permissions of %s: %w", target, err))
+	}
+
+	uid, gid, err := outputOwnership()
+	if err != nil {
+		return withClass("write", err)
+	}
+
+	if uid != -1 || gid != -1 {
+		if err := output.Chown(uid, gid); err != nil {
+			return withClass("write", fmt.Errorf("error setting owner of %s: %w", target, err))
+		}
+	}
+
+	return nil
+}
+
+// Replace an output file with its written temporary file, which is closed.
+// The temporary file gets the configured permissions and is synced to disk
+// before the rename, and the directory after it, so that a crash leaves
+// either the old or the new file behind.
+func replaceWithTemp(output *os.File, filename string) error {
+	defer output.Close()
+
+	if err := applyOutputPermissions(output, "temporary file"); err != nil {
+		return err
+	}
+
+	if err := output.Sync(); err != nil {
+		return withClass("write", fmt.Errorf("error syncing temporary file: %w", err))
+	}
+
+	if err := output.Close(); err != nil {
+		return withClass("write", fmt.Errorf("error closing temporary file: %w", err))
+	}
+
+	if err := os.Rename(output.Name(), filename); err != nil {
+		return withClass("rename", fmt.Errorf("error renaming file: %w", err))
+	}
+
+	// Persist the rename. Not every platform supports syncing directories.
+	if dir, err := os.Open(filepath.Dir(filename)); err == nil {
+		dir.Sync()
+		dir.Close()
+	}
+
+	return nil
+}
+
+// Atomically write a file, using write to produce its contents
+func writeFileAtomic(filename string, write func(w *bufio.Writer) error) error {
+	output, err := createTemp(filename)
+	if err != nil {
+		return withClass("write", fmt.Errorf("error opening temporary file: %w", err))
+	}
+	defer output.Close()
+
+	w := bufio.NewWriter(output)
+
+	if err := write(w); err != nil {
+		return withClass("write", fmt.Errorf("error writing to temporary file: %w", err))
+	}
+
+	if err := w.Flush(); err != nil {
+		return withClass("write", fmt.Errorf("error writing to temporary file: %w", err))
+	}
+
+	return replaceWithTemp(output, filename)
+}
+
+// Update the modification time of an output file which is still current, so
+// that its age shows when it was last refreshed
+func touchOutput(filename string) {
+	if filename == "" || isFIFO(filename) {
+		return
+	}
+
+	now := time.Now()
+	if err := os.Chtimes(filename, now, now); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		slog.Error("Error updating modification time of output file", "file", filename, "error", err.Error())
+	}
+}
+
+// Number of failed writes to each extra output file
+var extraOutputFailures = map[string]int{}
+
+// Write copies of the metric file to the extra output files. A failing
+// destination is logged and counted without failing the refresh, so that
+// e.g. an unavailable NFS export doesn't hold back the local metric file.
+func writeExtraOutputs(samples []sample) {
+	for _, filename := range *extraOutputs {
+		if err := writeOUIMetrics(filename, samples); err != nil {
+			slog.Error("Error writing extra OUI metric file", "output", filename, "error", err.Error())
+			extraOutputFailures[filename]++
+		}
+	}
+}
+
+// Build samples counting the failed writes to each extra output file
+func extraOutputSamples() []sample {
+	samples := []sample{}
+
+	for _, filename := range *extraOutputs {
+		samples = append(samples, sample{
+			name:   metricNameWithSuffix("collector_output_errors_total"),
+			labels: []label{{name: "output", value: filename}},
+			value:  float64(extraOutputFailures[filename]),
+		})
+	}
+
+	return samples
+}
+
+// Write the OUI database as a udev hwdb fragment, in the format of systemd's
+// 20-OUI.hwdb
+func writeHWDB(filename string, ouiMap map[string]string) error {
+	return writeFileAtomic(filename, func(w *bufio.Writer) error {
+		if _, err := fmt.Fprintf(w, "# This file is generated by %s from %s\n", binName, sourceURL()); err != nil {
+			return err
+		}
+
+		for _, oui := range slices.Sorted(maps.Keys(ouiMap)) {
+			// hwdb values end at the line break
+			organization := strings.ReplaceAll(ouiMap[oui], "\n", " ")
+
+			_, err := fmt.Fprintf(
+				w,
+				"\nOUI:%s*\n ID_OUI_FROM_DATABASE=%s\n",
+				strings.ToUpper(strings.ReplaceAll(oui, ":", "")),
+				organization,
+			)
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+// Compile a list of regular expressions
+func compilePatterns(exprs []string) ([]*regexp.Regexp, error) {
+	patterns := make([]*regexp.Regexp, 0, len(exprs))
+
+	for _, expr := range exprs {
+		pattern, err := regexp.Compile(expr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid regular expression %q: %w", expr, err)
+		}
+
+		patterns = append(patterns, pattern)
+	}
+
+	return patterns, nil
+}
+
+// Build the address and mask matching the MAC addresses of an OUI or prefix
+func aclMask(key string) (string, string) {
+	digits := strings.ReplaceAll(key, ":", "")
+
+	return ouidb.FormatPrefix(digits + strings.Repeat("0", 12-len(digits))),
+		ouidb.FormatPrefix(strings.Repeat("f", len(digits)) + strings.Repeat("0", 12-len(digits)))
+}
+
+// Write a MAC address ACL with the OUIs of organizations matching any of the
+// patterns, in the format of hostapd's accept_mac_file. Each OUI is written as
+// an address and mask, preceded by a comment naming the organization.
+func writeACL(filename string, ouiMap map[string]string, exprs []string) error {
+	patterns, err := compilePatterns(exprs)
+	if err != nil {
+		return err
+	}
+
+	return writeFileAtomic(filename, func(w *bufio.Writer) error {
+		if _, err := fmt.Fprintf(w, "# This file is generated by %s from %s\n", binName, sourceURL()); err != nil {
+			return err
+		}
+
+		for _, oui := range slices.Sorted(maps.Keys(ouiMap)) {
+			organization := ouiMap[oui]
+
+			if !slices.ContainsFunc(patterns, func(p *regexp.Regexp) bool { return p.MatchString(organization) }) {
+				continue
+			}
+
+			address, mask := aclMask(oui)
+
+			_, err := fmt.Fprintf(
+				w,
+				"# %s\n%s %s\n",
+				strings.ReplaceAll(organization, "\n", " "),
+				address,
+				mask,
+			)
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+// An OUI in the JSON outputs
+type ouiEntry struct {
+	OUI          string `json:"oui"`
+	Prefix       string `json:"prefix,omitempty"`
+	Registry     string `json:"registry,omitempty"`
+	Assignment   string `json:"assignment"`
+	Organization string `json:"organization_name"`
+}
+
+// Build the entry written to the JSON outputs for a record, only telling the
+// registry apart when registries other than MA-L are downloaded
+func newOUIEntry(r ouiRecord, organization string) ouiEntry {
+	entry := ouiEntry{
+		OUI:          r.oui,
+		Prefix:       r.prefix,
+		Assignment:   r.assignment,
+		Organization: organization,
+	}
+
+	if len(extraRegistries()) > 0 {
+		entry.Registry = r.registry
+	}
+
+	return entry
+}
+
+// Build the entries written to the JSON outputs, following the duplicate
+// policy of the metric file
+func ouiEntries(records []ouiRecord) []ouiEntry {
+	entries := make([]ouiEntry, 0, len(records))
+
+	for _, group := range groupRecords(records) {
+		if *duplicatePolicy == "records" {
+			for _, r := range group {
+				entries = append(entries, newOUIEntry(r, r.organization))
+			}
+
+			continue
+		}
+
+		entries = append(entries, newOUIEntry(group[0], joinOrganizations(group)))
+	}
+
+	return entries
+}
+
+// Write the OUI database as JSON Lines, with one object per OUI
+func writeJSONL(filename string, records []ouiRecord) error {
+	return writeFileAtomic(filename, func(w *bufio.Writer) error {
+		enc := json.NewEncoder(w)
+
+		for _, entry := range ouiEntries(records) {
+			if err := enc.Encode(entry); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+// Write the OUI database as a JSON object mapping each OUI to its
+// organization
+func writeJSON(filename string, records []ouiRecord) error {
+	return writeFileAtomic(filename, func(w *bufio.Writer) error {
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+
+		return enc.Encode(mergeRecords(records))
+	})
+}
+
+// Write the OUI database to the output file if --output-format selects a
+// format other than Prometheus metrics
+func writeOutputDatabase(filename string, records []ouiRecord) error {
+	if filename == "" {
+		return nil
+	}
+
+	switch *outputFormat {
+	case "json":
+		return writeJSON(filename, records)
+	case "sqlite":
+		return writeSQLite(filename, records)
+	}
+
+	return nil
+}
+
+// Write the OUI database as a YAML list of OUIs
+func writeYAML(filename string, records []ouiRecord) error {
+	return writeFileAtomic(filename, func(w *bufio.Writer) error {
+		if _, err := fmt.Fprintf(w, "# This file is generated by %s from %s\n", binName, sourceURL()); err != nil {
+			return err
+		}
+
+		for _, entry := range ouiEntries(records) {
+			// Go's quoted string escapes are a subset of YAML's double
+			// quoted escapes
+			if _, err := fmt.Fprintf(w, "- oui: %s\n", strconv.Quote(entry.OUI)); err != nil {
+				return err
+			}
+
+			if entry.Prefix != "" {
+				if _, err := fmt.Fprintf(w, "  prefix: %s\n", strconv.Quote(entry.Prefix)); err != nil {
+					return err
+				}
+			}
+
+			if entry.Registry != "" {
+				if _, err := fmt.Fprintf(w, "  registry: %s\n", strconv.Quote(entry.Registry)); err != nil {
+					return err
+				}
+			}
+
+			_, err := fmt.Fprintf(
+				w,
+				"  assignment: %s\n  organization_name: %s\n",
+				strconv.Quote(entry.Assignment),
+				strconv.Quote(entry.Organization),
+			)
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
@@ -0,0 +1,140 @@
+package ouidb
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// A Record is a row of an IEEE registry CSV file
+type Record struct {
+	// Registry column, e.g. MA-L
+	Registry string
+	// Assignment column verbatim, e.g. 00000C
+	Assignment string
+	// Organization name with surrounding whitespace removed
+	Organization string
+	// Organization address, empty if the row doesn't have one
+	Address string
+	// Line of the file the row starts on
+	Line int
+}
+
+// A HeaderError is returned if the header of a CSV file lacks a column which
+// is required to read its rows
+type HeaderError struct {
+	Column string
+}
+
+func (e *HeaderError) Error() string {
+	return fmt.Sprintf("header has no %q column", e.Column)
+}
+
+// A FieldCountError is returned for a row with too few fields to hold an
+// assignment and an organization name
+type FieldCountError struct {
+	Line int
+}
+
+func (e *FieldCountError) Error() string {
+	return fmt.Sprintf("row on line %d has too few fields", e.Line)
+}
+
+// A Decoder reads the records of an IEEE registry CSV file, finding the
+// columns by the names in its header
+type Decoder struct {
+	reader  *csv.Reader
+	header  bool
+	columns map[string]int
+}
+
+// Names of the columns of the IEEE registry CSV files
+const (
+	registryColumn     = "registry"
+	assignmentColumn   = "assignment"
+	organizationColumn = "organization name"
+	addressColumn      = "organization address"
+)
+
+// NewDecoder returns a decoder reading from r. In relaxed mode, malformed
+// quoting and rows with a varying number of fields are accepted.
+func NewDecoder(r io.Reader, relaxed bool) *Decoder {
+	reader := csv.NewReader(r)
+
+	if relaxed {
+		reader.LazyQuotes = true
+		reader.FieldsPerRecord = -1
+	}
+
+	return &Decoder{reader: reader}
+}
+
+// Next returns the next record, or io.EOF at the end of the file. A malformed
+// row returns a *csv.ParseError or a *FieldCountError, after which decoding
+// can continue with the next row. A header without the assignment or the
+// organization name column returns a *HeaderError.
+func (d *Decoder) Next() (Record, error) {
+	for {
+		entry, err := d.reader.Read()
+		if err != nil {
+			return Record{}, err
+		}
+
+		if !d.header {
+			if err := d.readHeader(entry); err != nil {
+				return Record{}, err
+			}
+
+			continue
+		}
+
+		line, _ := d.reader.FieldPos(0)
+
+		assignment, organization := d.columns[assignmentColumn], d.columns[organizationColumn]
+		if len(entry) <= max(assignment, organization) {
+			return Record{}, &FieldCountError{Line: line}
+		}
+
+		r := Record{
+			Assignment:   entry[assignment],
+			Organization: strings.TrimSpace(entry[organization]),
+			Line:         line,
+		}
+
+		if i, ok := d.columns[registryColumn]; ok && i < len(entry) {
+			r.Registry = entry[i]
+		}
+
+		if i, ok := d.columns[addressColumn]; ok && i < len(entry) {
+			r.Address = strings.TrimSpace(entry[i])
+		}
+
+		return r, nil
+	}
+}
+
+// Map the column names of the header to their positions, requiring the
+// assignment and organization name columns
+func (d *Decoder) readHeader(header []string) error {
+	d.header = true
+	d.columns = map[string]int{}
+
+	for i, name := range header {
+		// The IEEE files don't have a byte order mark, but files saved by
+		// spreadsheets often do
+		name = strings.ToLower(strings.TrimSpace(strings.TrimPrefix(name, "\ufeff")))
+
+		if _, exists := d.columns[name]; !exists {
+			d.columns[name] = i
+		}
+	}
+
+	for _, column := range []string{assignmentColumn, organizationColumn} {
+		if _, ok := d.columns[column]; !ok {
+			return &HeaderError{Column: column}
+		}
+	}
+
+	return nil
+}
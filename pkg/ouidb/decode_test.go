@@ -0,0 +1,216 @@
+package ouidb
+
+import (
+	"encoding/csv"
+	"errors"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// Decode every record of a CSV file, collecting the errors of malformed rows
+// and stopping at the first other error
+func decodeAll(input string, relaxed bool) ([]Record, []error) {
+	decoder := NewDecoder(strings.NewReader(input), relaxed)
+	records := []Record{}
+	errs := []error{}
+
+	for {
+		record, err := decoder.Next()
+		if err == io.EOF {
+			return records, errs
+		}
+
+		if err != nil {
+			errs = append(errs, err)
+
+			var parseErr *csv.ParseError
+			var fieldsErr *FieldCountError
+			if !errors.As(err, &parseErr) && !errors.As(err, &fieldsErr) {
+				return records, errs
+			}
+
+			continue
+		}
+
+		records = append(records, record)
+	}
+}
+
+func TestDecoder(t *testing.T) {
+	tests := []struct {
+		name    string
+		input   string
+		relaxed bool
+		want    []Record
+	}{
+		{
+			name: "IEEE file",
+			input: "Registry,Assignment,Organization Name,Organization Address\n" +
+				"MA-L,00000C,Cisco Systems Inc ,170 West Tasman Drive San Jose CA US 95134\n" +
+				"MA-M,70B3D51,Example Ltd,\n",
+			want: []Record{
+				{Registry: "MA-L", Assignment: "00000C", Organization: "Cisco Systems Inc", Address: "170 West Tasman Drive San Jose CA US 95134", Line: 2},
+				{Registry: "MA-M", Assignment: "70B3D51", Organization: "Example Ltd", Line: 3},
+			},
+		},
+		{
+			name: "columns found by name",
+			input: "Organization Name,Assignment\n" +
+				"Cisco Systems Inc,00000C\n",
+			want: []Record{
+				{Assignment: "00000C", Organization: "Cisco Systems Inc", Line: 2},
+			},
+		},
+		{
+			name: "byte order mark and case of header",
+			input: "\ufeffREGISTRY,assignment,ORGANIZATION NAME\n" +
+				"MA-L,00000C,Cisco Systems Inc\n",
+			want: []Record{
+				{Registry: "MA-L", Assignment: "00000C", Organization: "Cisco Systems Inc", Line: 2},
+			},
+		},
+		{
+			name: "quoted fields",
+			input: "Registry,Assignment,Organization Name,Organization Address\n" +
+				"MA-L,00000C,\"Cisco Systems, Inc\",\"San Jose\nCA\"\n" +
+				"MA-L,0000AA,Xerox Corporation,\n",
+			want: []Record{
+				{Registry: "MA-L", Assignment: "00000C", Organization: "Cisco Systems, Inc", Address: "San Jose\nCA", Line: 2},
+				{Registry: "MA-L", Assignment: "0000AA", Organization: "Xerox Corporation", Line: 4},
+			},
+		},
+		{
+			name: "relaxed quoting",
+			input: "Registry,Assignment,Organization Name\n" +
+				"MA-L,00000C,Cisco \"Systems\" Inc\n",
+			relaxed: true,
+			want: []Record{
+				{Registry: "MA-L", Assignment: "00000C", Organization: "Cisco \"Systems\" Inc", Line: 2},
+			},
+		},
+		{
+			name:  "header only",
+			input: "Registry,Assignment,Organization Name\n",
+			want:  []Record{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			records, errs := decodeAll(tt.input, tt.relaxed)
+
+			if len(errs) > 0 {
+				t.Fatalf("unexpected errors: %v", errs)
+			}
+
+			if !reflect.DeepEqual(records, tt.want) {
+				t.Errorf("records = %+v, want %+v", records, tt.want)
+			}
+		})
+	}
+}
+
+func TestDecoderMalformed(t *testing.T) {
+	tests := []struct {
+		name    string
+		input   string
+		relaxed bool
+		// Assignments of the records decoded around the malformed rows
+		want []string
+		// Checks the error of each malformed row, in order
+		errs []func(error) bool
+	}{
+		{
+			name:  "empty file",
+			input: "",
+			want:  []string{},
+		},
+		{
+			name: "missing assignment column",
+			input: "Registry,Organization Name\n" +
+				"MA-L,Cisco Systems Inc\n",
+			want: []string{},
+			errs: []func(error) bool{isHeaderError(assignmentColumn)},
+		},
+		{
+			name: "missing organization name column",
+			input: "Registry,Assignment\n" +
+				"MA-L,00000C\n",
+			want: []string{},
+			errs: []func(error) bool{isHeaderError(organizationColumn)},
+		},
+		{
+			name: "too few fields",
+			input: "Registry,Assignment,Organization Name\n" +
+				"MA-L,00000C\n" +
+				"MA-L,0000AA,Xerox Corporation\n",
+			relaxed: true,
+			want:    []string{"0000AA"},
+			errs:    []func(error) bool{isFieldCountError(2)},
+		},
+		{
+			name: "varying number of fields in strict mode",
+			input: "Registry,Assignment,Organization Name\n" +
+				"MA-L,00000C,Cisco Systems Inc,extra\n" +
+				"MA-L,0000AA,Xerox Corporation\n",
+			want: []string{"0000AA"},
+			errs: []func(error) bool{isParseError(csv.ErrFieldCount)},
+		},
+		{
+			name: "bare quote in strict mode",
+			input: "Registry,Assignment,Organization Name\n" +
+				"MA-L,00000C,Cisco \"Systems\" Inc\n" +
+				"MA-L,0000AA,Xerox Corporation\n",
+			want: []string{"0000AA"},
+			errs: []func(error) bool{isParseError(csv.ErrBareQuote)},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			records, errs := decodeAll(tt.input, tt.relaxed)
+
+			assignments := []string{}
+			for _, r := range records {
+				assignments = append(assignments, r.Assignment)
+			}
+
+			if !reflect.DeepEqual(assignments, tt.want) {
+				t.Errorf("assignments = %v, want %v", assignments, tt.want)
+			}
+
+			if len(errs) != len(tt.errs) {
+				t.Fatalf("errors = %v, want %d errors", errs, len(tt.errs))
+			}
+
+			for i, err := range errs {
+				if !tt.errs[i](err) {
+					t.Errorf("unexpected error %d: %v", i, err)
+				}
+			}
+		})
+	}
+}
+
+func isHeaderError(column string) func(error) bool {
+	return func(err error) bool {
+		var headerErr *HeaderError
+		return errors.As(err, &headerErr) && headerErr.Column == column
+	}
+}
+
+func isFieldCountError(line int) func(error) bool {
+	return func(err error) bool {
+		var fieldsErr *FieldCountError
+		return errors.As(err, &fieldsErr) && fieldsErr.Line == line
+	}
+}
+
+func isParseError(want error) func(error) bool {
+	return func(err error) bool {
+		var parseErr *csv.ParseError
+		return errors.As(err, &parseErr) && errors.Is(err, want)
+	}
+}
@@ -0,0 +1,241 @@
+// Package ouidb downloads and parses the IEEE registries of MAC address block
+// assignments, and looks up the organization which a MAC address is assigned
+// to.
+package ouidb
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+)
+
+// URLs of the IEEE registry CSV files
+const (
+	MALURL = "https://standards-oui.ieee.org/oui/oui.csv"
+	MAMURL = "https://standards-oui.ieee.org/oui28/mam.csv"
+	MASURL = "https://standards-oui.ieee.org/oui36/oui36.csv"
+	IABURL = "https://standards-oui.ieee.org/iab/iab.csv"
+	CIDURL = "https://standards-oui.ieee.org/cid/cid.csv"
+)
+
+// Lengths in hex digits of the assignments of the registries, longest first
+var prefixDigits = []int{9, 7, 6}
+
+// An Org is the organization which a block of MAC addresses is assigned to
+type Org struct {
+	Name    string
+	Address string
+	// Registry the block is assigned in, e.g. MA-L
+	Registry string
+	// Prefix of the block as colon separated hex digits, e.g. 00:00:0c
+	Prefix string
+}
+
+// A DB holds the assignments of one or more IEEE registries. It is safe for
+// concurrent use.
+type DB struct {
+	// Client used to download the registries, http.DefaultClient if nil
+	Client *http.Client
+
+	urls []string
+
+	mu     sync.RWMutex
+	blocks map[string]Org
+}
+
+// Load downloads and parses the IEEE registries at the given URLs, or the
+// MA-L registry if none are given
+func Load(ctx context.Context, urls ...string) (*DB, error) {
+	if len(urls) == 0 {
+		urls = []string{MALURL}
+	}
+
+	db := &DB{urls: urls}
+
+	if err := db.Refresh(ctx); err != nil {
+		return nil, err
+	}
+
+	return db, nil
+}
+
+// LoadFiles parses local copies of IEEE registry CSV files
+func LoadFiles(filenames ...string) (*DB, error) {
+	db := &DB{blocks: map[string]Org{}}
+
+	for _, filename := range filenames {
+		f, err := os.Open(filename)
+		if err != nil {
+			return nil, fmt.Errorf("error opening registry file: %w", err)
+		}
+
+		err = db.add(db.blocks, f)
+		f.Close()
+
+		if err != nil {
+			return nil, fmt.Errorf("error parsing registry file %s: %w", filename, err)
+		}
+	}
+
+	return db, nil
+}
+
+// New returns a DB holding the given blocks, e.g. read from another source
+// than the IEEE CSV files
+func New(orgs ...Org) *DB {
+	db := &DB{blocks: map[string]Org{}}
+
+	for _, org := range orgs {
+		db.blocks[org.Prefix] = org
+	}
+
+	return db
+}
+
+// Refresh downloads the registries the DB was loaded from again, replacing
+// its assignments once all of them have been parsed
+func (db *DB) Refresh(ctx context.Context) error {
+	if len(db.urls) == 0 {
+		return errors.New("database wasn't loaded from URLs")
+	}
+
+	client := db.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	blocks := map[string]Org{}
+
+	for _, url := range db.urls {
+		if err := db.download(ctx, client, url, blocks); err != nil {
+			return fmt.Errorf("error refreshing %s: %w", url, err)
+		}
+	}
+
+	db.mu.Lock()
+	db.blocks = blocks
+	db.mu.Unlock()
+
+	return nil
+}
+
+func (db *DB) download(ctx context.Context, client *http.Client, url string, blocks map[string]Org) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected http status: %s", resp.Status)
+	}
+
+	return db.add(blocks, resp.Body)
+}
+
+// Parse a registry into blocks. Relaxed parsing is used throughout, as a
+// download can't be read again to retry after a strict parsing failure.
+func (db *DB) add(blocks map[string]Org, r io.Reader) error {
+	decoder := NewDecoder(r, true)
+
+	for {
+		record, err := decoder.Next()
+		if err == io.EOF {
+			return nil
+		}
+
+		var fieldsErr *FieldCountError
+		if errors.As(err, &fieldsErr) {
+			continue
+		}
+
+		if err != nil {
+			return err
+		}
+
+		digits := strings.ToLower(record.Assignment)
+		if !validPrefix(digits) {
+			continue
+		}
+
+		prefix := FormatPrefix(digits)
+
+		org := Org{
+			Name:     record.Organization,
+			Address:  record.Address,
+			Registry: record.Registry,
+			Prefix:   prefix,
+		}
+
+		// Organizations sharing a block are joined, as in the metric file
+		if existing, ok := blocks[prefix]; ok {
+			org.Name = existing.Name + " | " + org.Name
+		}
+
+		blocks[prefix] = org
+	}
+}
+
+// Check whether hex digits have the length of an assignment
+func validPrefix(digits string) bool {
+	for _, n := range prefixDigits {
+		if len(digits) == n {
+			return strings.Trim(digits, "0123456789abcdef") == ""
+		}
+	}
+
+	return false
+}
+
+// FormatPrefix formats hex digits as lower case colon separated pairs, e.g.
+// 0055DA0 as 00:55:da:0
+func FormatPrefix(digits string) string {
+	digits = strings.ToLower(digits)
+	pairs := []string{}
+
+	for i := 0; i < len(digits); i += 2 {
+		pairs = append(pairs, digits[i:min(i+2, len(digits))])
+	}
+
+	return strings.Join(pairs, ":")
+}
+
+// Lookup returns the organization which the longest matching block containing
+// a MAC address is assigned to
+func (db *DB) Lookup(mac net.HardwareAddr) (Org, bool) {
+	if len(mac) < 5 {
+		return Org{}, false
+	}
+
+	digits := fmt.Sprintf("%x", []byte(mac))
+
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	for _, n := range prefixDigits {
+		if org, ok := db.blocks[FormatPrefix(digits[:n])]; ok {
+			return org, true
+		}
+	}
+
+	return Org{}, false
+}
+
+// Len returns the number of blocks in the DB
+func (db *DB) Len() int {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	return len(db.blocks)
+}
@@ -0,0 +1,212 @@
+package ouidb
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// Registries with nested MA-L, MA-M and MA-S blocks
+const (
+	testMAL = "Registry,Assignment,Organization Name,Organization Address\n" +
+		"MA-L,70B3D5,IEEE Registration Authority,445 Hoes Lane Piscataway NJ US 08554\n" +
+		"MA-L,00000C,Cisco Systems Inc,170 West Tasman Drive San Jose CA US 95134\n"
+	testMAM = "Registry,Assignment,Organization Name,Organization Address\n" +
+		"MA-M,70B3D51,Medium Ltd,\n"
+	testMAS = "Registry,Assignment,Organization Name,Organization Address\n" +
+		"MA-S,70B3D51F2,Small Ltd,\n"
+)
+
+func writeRegistry(t *testing.T, name string, content string) string {
+	t.Helper()
+
+	filename := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(filename, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	return filename
+}
+
+func TestLookup(t *testing.T) {
+	db, err := LoadFiles(
+		writeRegistry(t, "oui.csv", testMAL),
+		writeRegistry(t, "mam.csv", testMAM),
+		writeRegistry(t, "oui36.csv", testMAS),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		mac    string
+		found  bool
+		name   string
+		prefix string
+	}{
+		{mac: "70:b3:d5:1f:2a:bc", found: true, name: "Small Ltd", prefix: "70:b3:d5:1f:2"},
+		{mac: "70:b3:d5:1f:3a:bc", found: true, name: "Medium Ltd", prefix: "70:b3:d5:1"},
+		{mac: "70:b3:d5:2f:2a:bc", found: true, name: "IEEE Registration Authority", prefix: "70:b3:d5"},
+		{mac: "00:00:0c:12:34:56", found: true, name: "Cisco Systems Inc", prefix: "00:00:0c"},
+		{mac: "00-00-0C-12-34-56", found: true, name: "Cisco Systems Inc", prefix: "00:00:0c"},
+		{mac: "00:00:0d:12:34:56", found: false},
+		{mac: "02:00:5e:10:00:00:00:01", found: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.mac, func(t *testing.T) {
+			mac, err := net.ParseMAC(tt.mac)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			org, ok := db.Lookup(mac)
+			if ok != tt.found {
+				t.Fatalf("found = %t, want %t", ok, tt.found)
+			}
+
+			if org.Name != tt.name || org.Prefix != tt.prefix {
+				t.Errorf("org = %+v, want name %q and prefix %q", org, tt.name, tt.prefix)
+			}
+		})
+	}
+
+	if org, ok := db.Lookup(net.HardwareAddr{0x00, 0x00, 0x0c, 0x12}); ok {
+		t.Errorf("address shorter than an MA-S prefix found %+v", org)
+	}
+}
+
+func TestLoadFilesMalformed(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		// Prefixes of the blocks which are loaded
+		want    []string
+		wantErr bool
+	}{
+		{
+			name: "invalid assignments are skipped",
+			content: "Registry,Assignment,Organization Name\n" +
+				"MA-L,00000C,Cisco Systems Inc\n" +
+				"MA-L,00000G,Not Hex\n" +
+				"MA-L,00000,Too Short\n" +
+				"MA-L,,Empty\n",
+			want: []string{"00:00:0c"},
+		},
+		{
+			name: "rows with too few fields are skipped",
+			content: "Registry,Assignment,Organization Name\n" +
+				"MA-L,00000C\n" +
+				"MA-L,0000AA,Xerox Corporation\n",
+			want: []string{"00:00:aa"},
+		},
+		{
+			name: "malformed quoting is accepted",
+			content: "Registry,Assignment,Organization Name\n" +
+				"MA-L,00000C,Cisco \"Systems\" Inc\n",
+			want: []string{"00:00:0c"},
+		},
+		{
+			name: "missing column",
+			content: "Registry,Organization Name\n" +
+				"MA-L,Cisco Systems Inc\n",
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, err := LoadFiles(writeRegistry(t, "oui.csv", tt.content))
+			if tt.wantErr {
+				if err == nil {
+					t.Fatal("expected an error")
+				}
+
+				return
+			}
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if db.Len() != len(tt.want) {
+				t.Errorf("Len() = %d, want %d", db.Len(), len(tt.want))
+			}
+
+			for _, prefix := range tt.want {
+				if _, ok := db.blocks[prefix]; !ok {
+					t.Errorf("block %s wasn't loaded", prefix)
+				}
+			}
+		})
+	}
+}
+
+func TestLoadFilesJoinsOrganizations(t *testing.T) {
+	db, err := LoadFiles(writeRegistry(t, "oui.csv", "Registry,Assignment,Organization Name\n"+
+		"MA-L,0050C2,IEEE Registration Authority\n"+
+		"MA-L,0050C2,Other Org\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	org, ok := db.Lookup(net.HardwareAddr{0x00, 0x50, 0xc2, 0x00, 0x00, 0x01})
+	if !ok || org.Name != "IEEE Registration Authority | Other Org" {
+		t.Errorf("org = %+v, found = %t", org, ok)
+	}
+}
+
+func TestFormatPrefix(t *testing.T) {
+	tests := []struct {
+		digits string
+		want   string
+	}{
+		{digits: "00000C", want: "00:00:0c"},
+		{digits: "70B3D51", want: "70:b3:d5:1"},
+		{digits: "70b3d51f2", want: "70:b3:d5:1f:2"},
+		{digits: "", want: ""},
+	}
+
+	for _, tt := range tests {
+		if got := FormatPrefix(tt.digits); got != tt.want {
+			t.Errorf("FormatPrefix(%q) = %q, want %q", tt.digits, got, tt.want)
+		}
+	}
+}
+
+func TestLoad(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/oui.csv":
+			w.Write([]byte(testMAL))
+		case "/mam.csv":
+			w.Write([]byte(testMAM))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	db, err := Load(context.Background(), server.URL+"/oui.csv", server.URL+"/mam.csv")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if db.Len() != 3 {
+		t.Errorf("Len() = %d, want 3", db.Len())
+	}
+
+	_, err = Load(context.Background(), server.URL+"/oui.csv", server.URL+"/missing.csv")
+	if err == nil || !strings.Contains(err.Error(), "404") {
+		t.Errorf("error = %v, want the 404 of the missing registry", err)
+	}
+
+	if err := New().Refresh(context.Background()); err == nil {
+		t.Error("expected an error refreshing a DB which wasn't loaded from URLs")
+	}
+}
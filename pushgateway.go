@@ -0,0 +1,99 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	neturl "net/url"
+	"os"
+	"slices"
+	"strings"
+)
+
+// Encode a grouping label as a pair of path segments of a Pushgateway URL.
+// Values which are empty or contain a slash are base64 encoded.
+func groupingSegments(name string, value string) string {
+	if value == "" {
+		return name + "@base64/="
+	}
+
+	if strings.Contains(value, "/") {
+		return name + "@base64/" + base64.RawURLEncoding.EncodeToString([]byte(value))
+	}
+
+	return name + "/" + neturl.PathEscape(value)
+}
+
+// Build the URL of the Pushgateway group which the metrics are pushed to
+func pushgatewayGroupURL() string {
+	instance := *pushgatewayInstance
+	if instance == "" {
+		instance, _ = os.Hostname()
+	}
+
+	return strings.TrimSuffix(*pushgatewayURL, "/") +
+		"/metrics/" + groupingSegments("job", *pushgatewayJob) +
+		"/" + groupingSegments("instance", instance)
+}
+
+// Push samples to the Pushgateway, replacing all metrics previously pushed to
+// the group
+func pushMetrics(ctx context.Context, samples []sample) error {
+	var body bytes.Buffer
+
+	w := bufio.NewWriter(&body)
+
+	if _, err := writeSamples(w, samples); err != nil {
+		return fmt.Errorf("error encoding metrics: %w", err)
+	}
+
+	if err := w.Flush(); err != nil {
+		return fmt.Errorf("error encoding metrics: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, pushgatewayGroupURL(), &body)
+	if err != nil {
+		return fmt.Errorf("error creating http request: %w", err)
+	}
+
+	req.Header.Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	req.Header.Set("User-Agent", userAgent)
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("error doing http request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode/100 != 2 {
+		// The Pushgateway explains why it rejected the metrics in the
+		// response body
+		message, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+
+		return fmt.Errorf("unexpected http status: %s: %s", resp.Status, strings.TrimSpace(string(message)))
+	}
+
+	return nil
+}
+
+// Push the samples of the metric file to the Pushgateway, if enabled. Only
+// the collector self-metrics are pushed unless --pushgateway-metrics is all,
+// leaving out the samples of single OUIs whatever their metric names are.
+func pushSamples(ctx context.Context, samples []sample) {
+	if *pushgatewayURL == "" {
+		return
+	}
+
+	if *pushgatewayMetrics == "self" {
+		samples = slices.DeleteFunc(slices.Clone(samples), func(s sample) bool { return s.perOUI })
+	}
+
+	if err := pushMetrics(ctx, samples); err != nil {
+		slog.Error("Error pushing metrics to Pushgateway", "error", err.Error())
+	}
+}
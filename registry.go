@@ -0,0 +1,173 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"sync"
+	"time"
+)
+
+// A registry which is refreshed alongside the OUI database and written to its
+// own metric file
+type registry struct {
+	name       string
+	url        string
+	outputFile string
+	parse      func(filename string, logger *slog.Logger) ([]sample, error)
+}
+
+// Build the registries enabled by the flags
+func enabledRegistries() []registry {
+	registries := []registry{}
+
+	if *ethertypeFile != "" {
+		registries = append(registries, registry{
+			name:       "ethertype",
+			url:        *ethertypeURL,
+			outputFile: *ethertypeFile,
+			parse:      parseEtherTypes,
+		})
+	}
+
+	if *bluetoothFile != "" {
+		registries = append(registries, registry{
+			name:       "bluetooth",
+			url:        *bluetoothURL,
+			outputFile: *bluetoothFile,
+			parse:      parseBluetoothCompanies,
+		})
+	}
+
+	if *usbFile != "" {
+		productMetric := ""
+		if *usbProducts {
+			productMetric = *usbProductMetric
+		}
+
+		registries = append(registries, registry{
+			name:       "usb",
+			url:        *usbURL,
+			outputFile: *usbFile,
+			parse: func(filename string, logger *slog.Logger) ([]sample, error) {
+				return parseIDs(filename, logger, *usbMetricName, productMetric)
+			},
+		})
+	}
+
+	if *pciFile != "" {
+		registries = append(registries, registry{
+			name:       "pci",
+			url:        *pciURL,
+			outputFile: *pciFile,
+			parse: func(filename string, logger *slog.Logger) ([]sample, error) {
+				return parseIDs(filename, logger, *pciMetricName, "")
+			},
+		})
+	}
+
+	if *enterpriseFile != "" {
+		registries = append(registries, registry{
+			name:       "enterprise",
+			url:        *enterpriseURL,
+			outputFile: *enterpriseFile,
+			parse:      parseEnterpriseNumbers,
+		})
+	}
+
+	return registries
+}
+
+// Download and parse a registry and write its samples to its metric file
+func refreshRegistry(ctx context.Context, r registry, logger *slog.Logger) error {
+	dl, err := fetch(ctx, r.url, r.name)
+	if dl.filename != "" {
+		defer os.Remove(dl.filename)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	samples, err := r.parse(dl.filename, logger)
+	if err != nil {
+		return err
+	}
+
+	if len(samples) == 0 {
+		return withClass("validation", fmt.Errorf("%s registry contains no valid entries", r.name))
+	}
+
+	return writeMetrics(r.outputFile, samples)
+}
+
+// Refresh a registry in the background at the given interval, backing off
+// when refreshes fail, until the context is cancelled. The loop is tracked by
+// wg, so that it can be waited for once cancelled.
+func startRegistry(ctx context.Context, wg *sync.WaitGroup, r registry, interval time.Duration) {
+	logger := slog.Default().With("registry", r.name)
+
+	wg.Go(func() {
+		retries := 0
+
+		for {
+			cycle := logger.With("cycle", newCycleID())
+			cycle.Info("Updating registry")
+
+			wait := interval
+
+			err := refreshRegistry(ctx, r, cycle)
+			if ctx.Err() != nil {
+				return
+			}
+
+			if err != nil {
+				wait = backoff(retries)
+				retries++
+
+				cycle.Error(
+					"Error updating registry",
+					"error",
+					err.Error(),
+					"class",
+					errorClass(err, "download"),
+					"retry",
+					wait,
+				)
+			} else {
+				retries = 0
+
+				cycle.Info("Successfully updated registry", "next", time.Now().Add(wait))
+			}
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(wait):
+			}
+		}
+	})
+}
+
+// Refresh every enabled registry once, for --oneshot
+func refreshRegistries(ctx context.Context) error {
+	errs := []error{}
+
+	for _, r := range enabledRegistries() {
+		logger := slog.Default().With("registry", r.name, "cycle", newCycleID())
+		logger.Info("Updating registry")
+
+		if err := refreshRegistry(ctx, r, logger); err != nil {
+			logger.Error("Error updating registry", "error", err.Error(), "class", errorClass(err, "download"))
+			errs = append(errs, fmt.Errorf("error updating %s registry: %w", r.name, err))
+
+			continue
+		}
+
+		logger.Info("Successfully updated registry")
+	}
+
+	return errors.Join(errs...)
+}
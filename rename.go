@@ -0,0 +1,63 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+)
+
+// Number of OUIs whose organization changed between refreshes since startup
+var renamedTotal int
+
+// An entry of the organization rename changelog
+type renameEntry struct {
+	Timestamp            time.Time `json:"timestamp"`
+	OUI                  string    `json:"oui"`
+	PreviousOrganization string    `json:"previous_organization"`
+	Organization         string    `json:"organization"`
+}
+
+// Append the renamed OUIs to the changelog as JSON lines
+func appendRenameLog(filename string, renamed []string, previous map[string]string, current map[string]string) error {
+	f, err := os.OpenFile(filename, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o644)
+	if err != nil {
+		return fmt.Errorf("error opening rename log file: %w", err)
+	}
+	defer f.Close()
+
+	w := bufio.NewWriter(f)
+	encoder := json.NewEncoder(w)
+	now := time.Now()
+
+	for _, oui := range renamed {
+		entry := renameEntry{
+			Timestamp:            now,
+			OUI:                  oui,
+			PreviousOrganization: previous[oui],
+			Organization:         current[oui],
+		}
+
+		if err := encoder.Encode(entry); err != nil {
+			return fmt.Errorf("error writing rename log file: %w", err)
+		}
+	}
+
+	if err := w.Flush(); err != nil {
+		return fmt.Errorf("error writing rename log file: %w", err)
+	}
+
+	return nil
+}
+
+// Count the OUIs whose organization changed since the previous refresh. The
+// count is only added to the total once the refresh is published.
+func renameSamples(changes ouiChanges) []sample {
+	return []sample{
+		{
+			name:  metricNameWithSuffix("renamed_total"),
+			value: float64(renamedTotal + len(changes.renamed)),
+		},
+	}
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+)
+
+// Path of the file holding the recorded response to a request
+func recordingPath(dir string, req *http.Request) string {
+	sum := sha256.Sum256([]byte(req.URL.String()))
+
+	return filepath.Join(dir, hex.EncodeToString(sum[:8])+".http")
+}
+
+// An http.RoundTripper which saves the raw responses to GET requests,
+// including their headers, so they can be replayed later. Only full 200
+// responses and redirects are recorded, so that a 304 to a conditional request
+// doesn't replace the recorded download, and a redirected download is replayed
+// by following the recorded redirects.
+type recordingTransport struct {
+	transport http.RoundTripper
+	dir       string
+}
+
+// Check whether a response with a status code is recorded
+func recordedStatus(code int) bool {
+	if code == http.StatusNotModified {
+		return false
+	}
+
+	return code == http.StatusOK || (code >= 300 && code < 400)
+}
+
+func (t *recordingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := t.transport.RoundTrip(req)
+	if err != nil || req.Method != http.MethodGet || !recordedStatus(resp.StatusCode) {
+		return resp, err
+	}
+
+	f, err := os.CreateTemp(t.dir, "*.http.tmp")
+	if err != nil {
+		resp.Body.Close()
+
+		return nil, fmt.Errorf("error recording http response: %w", err)
+	}
+
+	// The body has already been decoded, so the recording delimits it by
+	// the end of the file instead of its length or chunks
+	fmt.Fprintf(f, "HTTP/1.1 %s\r\n", resp.Status)
+	resp.Header.WriteSubset(f, map[string]bool{"Content-Length": true, "Connection": true})
+	if _, err := io.WriteString(f, "Connection: close\r\n\r\n"); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		resp.Body.Close()
+
+		return nil, fmt.Errorf("error recording http response: %w", err)
+	}
+
+	body := &recordingBody{ReadCloser: resp.Body, file: f, path: recordingPath(t.dir, req)}
+
+	// The client may not read the body of a redirect, which is recorded
+	// without it
+	if resp.StatusCode != http.StatusOK {
+		body.complete = true
+		body.ReadCloser = io.NopCloser(http.NoBody)
+
+		if err := body.Close(); err != nil {
+			resp.Body.Close()
+
+			return nil, err
+		}
+
+		return resp, nil
+	}
+
+	resp.Body = body
+
+	return resp, nil
+}
+
+// The body of a recorded response, which is copied to the recording as it is
+// read. The recording is only kept if the body was read to the end.
+type recordingBody struct {
+	io.ReadCloser
+	file     *os.File
+	path     string
+	complete bool
+	closed   bool
+	err      error
+}
+
+func (b *recordingBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+
+	if n > 0 && b.err == nil {
+		_, b.err = b.file.Write(p[:n])
+	}
+
+	if err == io.EOF {
+		b.complete = true
+	}
+
+	return n, err
+}
+
+func (b *recordingBody) Close() error {
+	err := b.ReadCloser.Close()
+	if b.closed {
+		return err
+	}
+
+	b.closed = true
+
+	if ferr := b.file.Close(); b.err == nil {
+		b.err = ferr
+	}
+
+	if !b.complete || b.err != nil {
+		os.Remove(b.file.Name())
+
+		if b.err != nil {
+			return fmt.Errorf("error recording http response: %w", b.err)
+		}
+
+		return err
+	}
+
+	if err := os.Rename(b.file.Name(), b.path); err != nil {
+		os.Remove(b.file.Name())
+
+		return fmt.Errorf("error recording http response: %w", err)
+	}
+
+	return err
+}
+
+// An http.RoundTripper which answers GET requests with responses saved by a
+// recordingTransport instead of sending them
+type replayTransport struct {
+	transport http.RoundTripper
+	dir       string
+}
+
+func (t *replayTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Method != http.MethodGet {
+		return t.transport.RoundTrip(req)
+	}
+
+	f, err := os.Open(recordingPath(t.dir, req))
+	if err != nil {
+		return nil, fmt.Errorf("error opening recorded response for %s: %w", req.URL, err)
+	}
+
+	resp, err := http.ReadResponse(bufio.NewReader(f), req)
+	if err != nil {
+		f.Close()
+
+		return nil, fmt.Errorf("error reading recorded response for %s: %w", req.URL, err)
+	}
+
+	resp.Body = replayBody{ReadCloser: resp.Body, file: f}
+
+	return resp, nil
+}
+
+// The body of a replayed response, which closes the recording along with it
+type replayBody struct {
+	io.ReadCloser
+	file *os.File
+}
+
+func (b replayBody) Close() error {
+	b.ReadCloser.Close()
+
+	return b.file.Close()
+}
@@ -0,0 +1,198 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// A cron schedule of the standard five fields: minute, hour, day of month,
+// month and day of week
+type cronSchedule struct {
+	minutes  [60]bool
+	hours    [24]bool
+	days     [32]bool
+	months   [13]bool
+	weekdays [7]bool
+	// Whether the day of month and the day of week fields are unrestricted.
+	// If both are restricted, a day only has to match one of them.
+	anyDay     bool
+	anyWeekday bool
+}
+
+// Parse one field of a cron expression into the values it matches. A field
+// is a comma separated list of *, a value or a range, each optionally
+// followed by a /step.
+//
+// As in cron, a field starting with * is unrestricted, which matters for the
+// day fields.
+func parseCronField(field string, matches []bool, first int, last int) (bool, error) {
+	for _, part := range strings.Split(field, ",") {
+		expr, stepText, hasStep := strings.Cut(part, "/")
+
+		step := 1
+		if hasStep {
+			n, err := strconv.Atoi(stepText)
+			if err != nil || n < 1 {
+				return false, fmt.Errorf("invalid step %q", stepText)
+			}
+
+			step = n
+		}
+
+		low, high := first, last
+
+		switch {
+		case expr == "*":
+		case strings.Contains(expr, "-"):
+			lowText, highText, _ := strings.Cut(expr, "-")
+
+			var err error
+			if low, err = strconv.Atoi(lowText); err != nil {
+				return false, fmt.Errorf("invalid value %q", lowText)
+			}
+
+			if high, err = strconv.Atoi(highText); err != nil {
+				return false, fmt.Errorf("invalid value %q", highText)
+			}
+		default:
+			n, err := strconv.Atoi(expr)
+			if err != nil {
+				return false, fmt.Errorf("invalid value %q", expr)
+			}
+
+			low = n
+			if !hasStep {
+				high = n
+			}
+		}
+
+		if low < first || high > last || low > high {
+			return false, fmt.Errorf("%q is out of range %d-%d", part, first, last)
+		}
+
+		for i := low; i <= high; i += step {
+			matches[i] = true
+		}
+	}
+
+	return strings.HasPrefix(field, "*"), nil
+}
+
+// Parse a cron expression such as "0 3 * * 1". Day of week 7 means Sunday, as
+// does 0.
+func parseCronSchedule(expr string) (*cronSchedule, error) {
+	fields := strings.Fields(expr)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("expected 5 fields, got %d", len(fields))
+	}
+
+	s := &cronSchedule{}
+	weekdays := [8]bool{}
+
+	specs := []struct {
+		name    string
+		matches []bool
+		first   int
+		last    int
+		any     *bool
+	}{
+		{"minute", s.minutes[:], 0, 59, new(bool)},
+		{"hour", s.hours[:], 0, 23, new(bool)},
+		{"day of month", s.days[:], 1, 31, &s.anyDay},
+		{"month", s.months[:], 1, 12, new(bool)},
+		{"day of week", weekdays[:], 0, 7, &s.anyWeekday},
+	}
+
+	for i, spec := range specs {
+		wildcard, err := parseCronField(fields[i], spec.matches, spec.first, spec.last)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s field: %w", spec.name, err)
+		}
+
+		*spec.any = wildcard
+	}
+
+	copy(s.weekdays[:], weekdays[:7])
+	s.weekdays[0] = s.weekdays[0] || weekdays[7]
+
+	return s, nil
+}
+
+// Check whether the schedule matches the day of a time. As in cron, a day
+// matches either field if both are restricted.
+func (s *cronSchedule) matchesDay(t time.Time) bool {
+	day := s.days[t.Day()]
+	weekday := s.weekdays[t.Weekday()]
+
+	if s.anyDay || s.anyWeekday {
+		return day && weekday
+	}
+
+	return day || weekday
+}
+
+// Find the first time after t which the schedule matches, or the zero time if
+// it never does, e.g. for February 30
+func (s *cronSchedule) next(t time.Time) time.Time {
+	t = t.Truncate(time.Minute).Add(time.Minute)
+
+	// Every combination of month, day and weekday recurs within 28 years
+	end := t.AddDate(28, 0, 0)
+
+	for t.Before(end) {
+		if !s.months[t.Month()] {
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, t.Location())
+			continue
+		}
+
+		if !s.matchesDay(t) {
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, t.Location())
+			continue
+		}
+
+		if !s.hours[t.Hour()] {
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, t.Location())
+			continue
+		}
+
+		if !s.minutes[t.Minute()] {
+			t = t.Add(time.Minute)
+			continue
+		}
+
+		return t
+	}
+
+	return time.Time{}
+}
+
+// Find the time of the refresh following a successful one, from the schedule
+// if one is set and otherwise after the interval
+func nextScheduledRefresh(schedule *cronSchedule, interval time.Duration) time.Time {
+	if schedule == nil {
+		return time.Now().Add(interval)
+	}
+
+	return schedule.next(time.Now())
+}
+
+// Parse the interval and the schedule of refreshes from the flags
+func refreshTiming() (time.Duration, *cronSchedule, error) {
+	interval, err := time.ParseDuration(*refreshInterval)
+	if err != nil {
+		return 0, nil, fmt.Errorf("error parsing refresh interval %q: %w", *refreshInterval, err)
+	}
+
+	if *refreshSchedule == "" {
+		return scaleDuration(interval), nil, nil
+	}
+
+	schedule, err := parseCronSchedule(*refreshSchedule)
+	if err != nil {
+		return 0, nil, fmt.Errorf("error parsing refresh schedule %q: %w", *refreshSchedule, err)
+	}
+
+	return scaleDuration(interval), schedule, nil
+}
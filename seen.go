@@ -0,0 +1,122 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+)
+
+var (
+	// Companion samples written after the OUI samples in the metric file on
+	// the most recent refresh
+	extraSamples []sample
+
+	// OUI records written to the metric file on the most recent refresh
+	ouiRecords []ouiRecord
+)
+
+// Build the samples of the OUI records written to the metric file: their info
+// series, their number and, if tracked, their history
+func recordSamples(records []ouiRecord) []sample {
+	samples := append(ouiSamples(records), entriesSample(records))
+
+	return append(samples, historySamples(ouiHistories, records)...)
+}
+
+// Build the sample counting the OUIs written to the metric file, once the
+// organization and seen-only filters are applied. The number of OUIs is a
+// gauge, so it is named entries rather than entries_total, which is the name
+// of a counter.
+func entriesSample(records []ouiRecord) sample {
+	return sample{
+		name:  metricNameWithSuffix("entries"),
+		value: float64(len(groupRecords(records))),
+	}
+}
+
+// Check whether the metric file should only contain locally seen OUIs
+func seenOnly() bool {
+	return *seenOnlyFile != "" || *seenOnlyNeighbors
+}
+
+// Read a file listing MAC addresses or OUIs, one per line
+func readSeenFile(filename string, seen map[string]bool) error {
+	f, err := os.Open(filename)
+	if err != nil {
+		return fmt.Errorf("error opening seen-only file: %w", err)
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		// Only the first three octets of a MAC address are the OUI
+		hex := strings.NewReplacer(":", "", "-", "", ".", "").Replace(line)
+		if oui, ok := normalizeOUI(hex[:min(len(hex), 6)]); ok {
+			seen[oui] = true
+		} else {
+			slog.Warn("Ignoring invalid MAC address or OUI in seen-only file", "entry", line)
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("error reading seen-only file: %w", err)
+	}
+
+	return nil
+}
+
+// Collect the OUIs which have been seen locally
+func seenOUIs() (map[string]bool, error) {
+	seen := map[string]bool{}
+
+	if *seenOnlyFile != "" {
+		if err := readSeenFile(*seenOnlyFile, seen); err != nil {
+			return nil, err
+		}
+	}
+
+	if *seenOnlyNeighbors {
+		neighbors, err := readNeighbors()
+		if err != nil {
+			return nil, err
+		}
+
+		for _, n := range neighbors {
+			seen[macOUI(n.mac)] = true
+		}
+	}
+
+	return seen, nil
+}
+
+// Reduce the OUI database to the locally seen OUIs, if enabled. If the seen
+// OUIs can't be determined, an error is returned so that the previous output
+// is kept, rather than publishing the full database.
+func filterSeen(records []ouiRecord) ([]ouiRecord, error) {
+	if !seenOnly() {
+		return records, nil
+	}
+
+	seen, err := seenOUIs()
+	if err != nil {
+		return nil, withClass("seen", fmt.Errorf("error collecting seen OUIs: %w", err))
+	}
+
+	filtered := []ouiRecord{}
+
+	for _, r := range records {
+		if seen[r.oui] {
+			filtered = append(filtered, r)
+		}
+	}
+
+	return filtered, nil
+}
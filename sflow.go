@@ -0,0 +1,275 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"sync"
+	"time"
+)
+
+// sFlow v5 sample and record formats, see https://sflow.org/sflow_version_5.txt
+const (
+	sflowFlowSample         = 1
+	sflowExpandedFlowSample = 3
+	sflowRawPacketHeader    = 1
+	sflowEthernetFrame      = 2
+	sflowHeaderEthernet     = 1
+)
+
+var errSFlowTruncated = errors.New("truncated sFlow datagram")
+
+// Traffic counters of sampled frames since the previous report, keyed by
+// source OUI
+type sflowCounters struct {
+	mu      sync.Mutex
+	frames  map[string]float64
+	bytes   map[string]float64
+	devices *seenSet
+}
+
+var sflowStats = &sflowCounters{
+	frames: map[string]float64{},
+	bytes:  map[string]float64{},
+	devices: &seenSet{
+		seen: map[string]time.Time{},
+	},
+}
+
+// Traffic counters of sampled frames, keyed by organization. The frames
+// counted per OUI since the previous report are added under the current
+// organization of the OUI, so that the series of an organization keeps
+// increasing or stays as it was when an OUI is renamed.
+var sflowTotals = struct {
+	frames map[string]float64
+	bytes  map[string]float64
+}{
+	frames: map[string]float64{},
+	bytes:  map[string]float64{},
+}
+
+// Record a sampled frame, scaling it by the sampling rate
+func (c *sflowCounters) observe(src net.HardwareAddr, length uint32, rate uint32) {
+	if len(src) != 6 || src[0]&0x01 != 0 {
+		return
+	}
+
+	oui := macOUI(src)
+
+	c.mu.Lock()
+	c.frames[oui] += float64(rate)
+	c.bytes[oui] += float64(length) * float64(rate)
+	c.mu.Unlock()
+
+	c.devices.observe(append(net.HardwareAddr(nil), src...))
+}
+
+// A reader over the big-endian XDR encoded fields of an sFlow datagram
+type xdrReader struct {
+	data []byte
+	err  error
+}
+
+func (r *xdrReader) uint32() uint32 {
+	if r.err != nil || len(r.data) < 4 {
+		r.err = errSFlowTruncated
+
+		return 0
+	}
+
+	v := binary.BigEndian.Uint32(r.data)
+	r.data = r.data[4:]
+
+	return v
+}
+
+// Read n bytes, skipping the padding to a multiple of 4
+func (r *xdrReader) bytes(n uint32) []byte {
+	padded := (uint64(n) + 3) &^ 3
+	if r.err != nil || uint64(len(r.data)) < padded {
+		r.err = errSFlowTruncated
+
+		return nil
+	}
+
+	v := r.data[:n]
+	r.data = r.data[padded:]
+
+	return v
+}
+
+// Parse an sFlow v5 datagram, passing every sampled frame to observe
+func parseSFlow(datagram []byte, observe func(src net.HardwareAddr, length uint32, rate uint32)) error {
+	r := &xdrReader{data: datagram}
+
+	if version := r.uint32(); r.err == nil && version != 5 {
+		return fmt.Errorf("unsupported sFlow version %d", version)
+	}
+
+	switch r.uint32() {
+	case 1:
+		r.bytes(4)
+	case 2:
+		r.bytes(16)
+	default:
+		return fmt.Errorf("unsupported sFlow agent address type")
+	}
+
+	// Sub agent ID, sequence number and uptime
+	r.bytes(12)
+
+	samples := r.uint32()
+
+	for i := uint32(0); i < samples && r.err == nil; i++ {
+		format := r.uint32()
+		sample := &xdrReader{data: r.bytes(r.uint32())}
+
+		if format != sflowFlowSample && format != sflowExpandedFlowSample {
+			continue
+		}
+
+		if format == sflowFlowSample {
+			// Sequence number and source ID
+			sample.bytes(8)
+		} else {
+			// Sequence number, source ID type and index
+			sample.bytes(12)
+		}
+
+		rate := sample.uint32()
+
+		if format == sflowFlowSample {
+			// Sample pool, drops, input and output interfaces
+			sample.bytes(16)
+		} else {
+			// Sample pool, drops, input and output interface formats and values
+			sample.bytes(24)
+		}
+
+		records := sample.uint32()
+
+		for j := uint32(0); j < records && sample.err == nil; j++ {
+			recordFormat := sample.uint32()
+			record := &xdrReader{data: sample.bytes(sample.uint32())}
+
+			switch recordFormat {
+			case sflowRawPacketHeader:
+				protocol := record.uint32()
+				length := record.uint32()
+				record.uint32() // Stripped bytes
+				header := record.bytes(record.uint32())
+
+				if record.err == nil && protocol == sflowHeaderEthernet && len(header) >= 12 {
+					observe(net.HardwareAddr(header[6:12]), length, rate)
+				}
+			case sflowEthernetFrame:
+				length := record.uint32()
+				src := record.bytes(6)
+
+				if record.err == nil {
+					observe(net.HardwareAddr(src), length, rate)
+				}
+			}
+		}
+
+		if sample.err != nil {
+			return sample.err
+		}
+	}
+
+	return r.err
+}
+
+// Start listening for sFlow datagrams
+func startSFlow(addr string) error {
+	conn, err := net.ListenPacket("udp", addr)
+	if err != nil {
+		return fmt.Errorf("error listening for sFlow: %w", err)
+	}
+
+	slog.Info("Listening for sFlow datagrams", "address", conn.LocalAddr().String())
+
+	go func() {
+		defer conn.Close()
+
+		buf := make([]byte, 65535)
+
+		for {
+			n, agent, err := conn.ReadFrom(buf)
+			if err != nil {
+				slog.Error("Error reading sFlow datagram", "error", err.Error())
+
+				return
+			}
+
+			if err := parseSFlow(buf[:n], sflowStats.observe); err != nil {
+				slog.Debug("Error parsing sFlow datagram", "agent", agent.String(), "error", err.Error())
+			}
+		}
+	}()
+
+	return nil
+}
+
+// Build samples of the traffic and devices of each vendor seen in sFlow
+func sflowSamples(ouiMap map[string]string) []sample {
+	sflowStats.mu.Lock()
+	for oui, v := range sflowStats.frames {
+		sflowTotals.frames[ouiMap[oui]] += v
+	}
+	for oui, v := range sflowStats.bytes {
+		sflowTotals.bytes[ouiMap[oui]] += v
+	}
+	clear(sflowStats.frames)
+	clear(sflowStats.bytes)
+	sflowStats.mu.Unlock()
+
+	devices := map[string]int{}
+	macs := sflowStats.devices.expire(*captureTTL)
+
+	for _, mac := range macs {
+		devices[ouiMap[macOUI(mac)]]++
+	}
+
+	samples := []sample{}
+
+	for organization, v := range sflowTotals.frames {
+		samples = append(samples,
+			sample{
+				name:   *sflowMetricPrefix + "_frames_total",
+				labels: []label{{name: "organization_name", value: organization}},
+				value:  v,
+			},
+			sample{
+				name:   *sflowMetricPrefix + "_bytes_total",
+				labels: []label{{name: "organization_name", value: organization}},
+				value:  sflowTotals.bytes[organization],
+			},
+		)
+	}
+
+	for organization, count := range devices {
+		samples = append(samples, sample{
+			name:   *sflowMetricPrefix + "_devices",
+			labels: []label{{name: "organization_name", value: organization}},
+			value:  float64(count),
+		})
+	}
+
+	groups := map[string][]net.HardwareAddr{"": macs}
+
+	return append(samples, randomizationSamples("sflow", "", groups)...)
+}
+
+// Update the sFlow metric file, if enabled and the OUI database is loaded
+func reportSFlow(ouiMap map[string]string) {
+	if *sflowFile == "" || *sflowListen == "" || ouiMap == nil {
+		return
+	}
+
+	if err := writeMetrics(*sflowFile, sflowSamples(ouiMap)); err != nil {
+		slog.Error("Error writing sFlow metric file", "error", err.Error())
+	}
+}
@@ -0,0 +1,255 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gosnmp/gosnmp"
+)
+
+const (
+	// BRIDGE-MIB::dot1dBasePortIfIndex
+	oidBasePortIfIndex = ".1.3.6.1.2.1.17.1.4.1.2"
+	// BRIDGE-MIB::dot1dTpFdbPort, indexed by MAC address
+	oidTpFdbPort = ".1.3.6.1.2.1.17.4.3.1.2"
+	// Q-BRIDGE-MIB::dot1qTpFdbPort, indexed by FDB ID and MAC address
+	oidQTpFdbPort = ".1.3.6.1.2.1.17.7.1.2.2.1.2"
+	// IF-MIB::ifName
+	oidIfName = ".1.3.6.1.2.1.31.1.1.1.1"
+)
+
+// An entry in a switch's MAC forwarding table
+type fdbEntry struct {
+	mac  net.HardwareAddr
+	vlan string
+	port string
+}
+
+// Create an SNMP client for a target given as host or host:port, whose
+// requests are cancelled with ctx
+func newSNMPClient(ctx context.Context, target string) (*gosnmp.GoSNMP, error) {
+	host, port := target, uint16(161)
+
+	if h, p, err := net.SplitHostPort(target); err == nil {
+		n, err := strconv.ParseUint(p, 10, 16)
+		if err != nil {
+			return nil, fmt.Errorf("invalid SNMP port %q", p)
+		}
+
+		host, port = h, uint16(n)
+	}
+
+	version := gosnmp.Version2c
+	if *snmpVersion == "1" {
+		version = gosnmp.Version1
+	}
+
+	return &gosnmp.GoSNMP{
+		Context:            ctx,
+		Target:             host,
+		Port:               port,
+		Community:          *snmpCommunity,
+		Version:            version,
+		Timeout:            5 * time.Second,
+		Retries:            1,
+		ExponentialTimeout: true,
+		MaxOids:            gosnmp.MaxOids,
+		MaxRepetitions:     25,
+	}, nil
+}
+
+// Walk a table, using GETBULK where the SNMP version supports it
+func walkSNMP(client *gosnmp.GoSNMP, oid string) ([]gosnmp.SnmpPDU, error) {
+	if client.Version == gosnmp.Version1 {
+		return client.WalkAll(oid)
+	}
+
+	return client.BulkWalkAll(oid)
+}
+
+// Split the index from the OID of a table column instance
+func snmpIndex(name string, column string) []int {
+	index := []int{}
+
+	for _, part := range strings.Split(strings.TrimPrefix(name, column+"."), ".") {
+		n, err := strconv.Atoi(part)
+		if err != nil {
+			return nil
+		}
+
+		index = append(index, n)
+	}
+
+	return index
+}
+
+// Convert the trailing six sub-identifiers of an index to a MAC address
+func indexMAC(index []int) net.HardwareAddr {
+	if len(index) < 6 {
+		return nil
+	}
+
+	mac := make(net.HardwareAddr, 6)
+	for i, n := range index[len(index)-6:] {
+		mac[i] = byte(n)
+	}
+
+	return mac
+}
+
+// Read the MAC forwarding table of a switch, preferring the VLAN aware
+// Q-BRIDGE-MIB table over the BRIDGE-MIB one
+func pollFDB(ctx context.Context, target string) ([]fdbEntry, error) {
+	client, err := newSNMPClient(ctx, target)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := client.Connect(); err != nil {
+		return nil, fmt.Errorf("error connecting to SNMP target: %w", err)
+	}
+	defer client.Conn.Close()
+
+	// gosnmp only checks the context between retries, so closing the
+	// connection is what interrupts a request which is waiting for a reply
+	stop := context.AfterFunc(ctx, func() { client.Conn.Close() })
+	defer stop()
+
+	ifNames := map[int]string{}
+
+	pdus, err := walkSNMP(client, oidIfName)
+	if err != nil {
+		return nil, fmt.Errorf("error walking ifName: %w", err)
+	}
+
+	for _, pdu := range pdus {
+		if index := snmpIndex(pdu.Name, oidIfName); len(index) == 1 {
+			if name, ok := pdu.Value.([]byte); ok {
+				ifNames[index[0]] = string(name)
+			}
+		}
+	}
+
+	portNames := map[int]string{}
+
+	pdus, err = walkSNMP(client, oidBasePortIfIndex)
+	if err != nil {
+		return nil, fmt.Errorf("error walking dot1dBasePortIfIndex: %w", err)
+	}
+
+	for _, pdu := range pdus {
+		if index := snmpIndex(pdu.Name, oidBasePortIfIndex); len(index) == 1 {
+			ifIndex := int(gosnmp.ToBigInt(pdu.Value).Int64())
+			if name, exists := ifNames[ifIndex]; exists {
+				portNames[index[0]] = name
+			}
+		}
+	}
+
+	portName := func(port int) string {
+		if name, exists := portNames[port]; exists {
+			return name
+		}
+
+		return strconv.Itoa(port)
+	}
+
+	entries := []fdbEntry{}
+
+	pdus, err = walkSNMP(client, oidQTpFdbPort)
+	if err != nil {
+		return nil, fmt.Errorf("error walking dot1qTpFdbPort: %w", err)
+	}
+
+	for _, pdu := range pdus {
+		index := snmpIndex(pdu.Name, oidQTpFdbPort)
+		if len(index) != 7 {
+			continue
+		}
+
+		entries = append(entries, fdbEntry{
+			mac:  indexMAC(index),
+			vlan: strconv.Itoa(index[0]),
+			port: portName(int(gosnmp.ToBigInt(pdu.Value).Int64())),
+		})
+	}
+
+	if len(entries) > 0 {
+		return entries, nil
+	}
+
+	pdus, err = walkSNMP(client, oidTpFdbPort)
+	if err != nil {
+		return nil, fmt.Errorf("error walking dot1dTpFdbPort: %w", err)
+	}
+
+	for _, pdu := range pdus {
+		index := snmpIndex(pdu.Name, oidTpFdbPort)
+		if len(index) != 6 {
+			continue
+		}
+
+		entries = append(entries, fdbEntry{
+			mac:  indexMAC(index),
+			port: portName(int(gosnmp.ToBigInt(pdu.Value).Int64())),
+		})
+	}
+
+	return entries, nil
+}
+
+// Build vendor samples for the MAC forwarding tables of all SNMP targets
+func snmpSamples(ctx context.Context, ouiMap map[string]string) []sample {
+	samples := []sample{}
+	switches := map[string][]net.HardwareAddr{}
+
+	for _, target := range *snmpTargets {
+		entries, err := pollFDB(ctx, target)
+		if err != nil {
+			slog.Error("Error polling switch forwarding table", "target", target, "error", err.Error())
+
+			continue
+		}
+
+		slices.SortFunc(entries, func(a, b fdbEntry) int {
+			return strings.Compare(a.port+a.vlan+a.mac.String(), b.port+b.vlan+b.mac.String())
+		})
+
+		seen := map[string]bool{}
+
+		for _, entry := range entries {
+			// A MAC address can be learned on several VLANs
+			if !seen[entry.mac.String()] {
+				seen[entry.mac.String()] = true
+				switches[target] = append(switches[target], entry.mac)
+			}
+
+			samples = append(samples, vendorSample(
+				*snmpMetricName,
+				ouiMap,
+				entry.mac,
+				label{name: "switch", value: target},
+				label{name: "port", value: entry.port},
+				label{name: "vlan", value: entry.vlan},
+			))
+		}
+	}
+
+	return append(samples, randomizationSamples("snmp", "switch", switches)...)
+}
+
+// Poll the switches for the SNMP metric file, if enabled and the OUI database
+// is loaded
+func pollSNMP(ctx context.Context, ouiMap map[string]string) (enrichmentOutput, bool) {
+	if *snmpFile == "" || len(*snmpTargets) == 0 || ouiMap == nil {
+		return enrichmentOutput{}, false
+	}
+
+	return enrichmentOutput{filename: *snmpFile, samples: snmpSamples(ctx, ouiMap)}, true
+}
@@ -0,0 +1,277 @@
+//go:build (darwin && (amd64 || arm64)) || (freebsd && (386 || amd64 || arm || arm64)) || (linux && (386 || amd64 || arm || arm64)) || (netbsd && amd64) || (openbsd && (amd64 || arm64))
+
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"os"
+
+	"github.com/adaricorp/oui-textfile-collector/pkg/ouidb"
+	_ "modernc.org/sqlite"
+)
+
+// Write the OUI database as a SQLite database with an ouis table, indexed by
+// OUI. If the output file exists and at most --sqlite-delta-max rows changed,
+// the changes are applied to it in place in a single transaction. Otherwise
+// the database is built in a temporary file which then replaces the output
+// file. Either way, readers never see a partially written database.
+func writeSQLite(filename string, records []ouiRecord) error {
+	entries := ouiEntries(records)
+
+	if *sqliteDeltaMax > 0 {
+		updated, err := updateSQLite(filename, entries)
+		if err != nil {
+			slog.Warn("Error updating SQLite output file in place, rebuilding it", "error", err.Error())
+		} else if updated {
+			output, err := os.Open(filename)
+			if err != nil {
+				return withClass("write", fmt.Errorf("error opening SQLite output file: %w", err))
+			}
+			defer output.Close()
+
+			return applyOutputPermissions(output, filename)
+		}
+	}
+
+	if err := os.Remove(filename + ".tmp"); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return withClass("write", fmt.Errorf("error removing temporary file: %w", err))
+	}
+
+	if err := buildSQLite(filename+".tmp", entries); err != nil {
+		os.Remove(filename + ".tmp")
+		return withClass("write", err)
+	}
+
+	output, err := os.OpenFile(filename+".tmp", os.O_WRONLY, 0)
+	if err != nil {
+		return withClass("write", fmt.Errorf("error opening temporary file: %w", err))
+	}
+
+	return replaceWithTemp(output, filename)
+}
+
+// A row of the ouis table, with NULL columns read as empty strings
+type sqliteRow struct {
+	oui          string
+	prefix       string
+	registry     string
+	assignment   string
+	organization string
+}
+
+func newSQLiteRow(entry ouiEntry) sqliteRow {
+	return sqliteRow{
+		oui:          entry.OUI,
+		prefix:       entry.Prefix,
+		registry:     entry.Registry,
+		assignment:   entry.Assignment,
+		organization: entry.Organization,
+	}
+}
+
+// Apply the changes between an existing SQLite output file and the entries
+// to it, returning false without changing it if it doesn't exist or more than
+// --sqlite-delta-max rows changed
+func updateSQLite(filename string, entries []ouiEntry) (bool, error) {
+	if _, err := os.Stat(filename); errors.Is(err, fs.ErrNotExist) {
+		return false, nil
+	}
+
+	// The database is opened read-write, so that a missing file isn't
+	// created
+	db, err := sql.Open("sqlite", "file:"+filename+"?mode=rw")
+	if err != nil {
+		return false, fmt.Errorf("error opening SQLite database: %w", err)
+	}
+	defer db.Close()
+
+	tx, err := db.Begin()
+	if err != nil {
+		return false, fmt.Errorf("error starting SQLite transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.Query("SELECT oui, prefix, registry, assignment, organization_name FROM ouis")
+	if err != nil {
+		return false, fmt.Errorf("error querying SQLite database: %w", err)
+	}
+
+	// Rows may repeat, so they are counted rather than collected in a set
+	changes := map[sqliteRow]int{}
+
+	for rows.Next() {
+		var row sqliteRow
+		var prefix, registry sql.NullString
+
+		if err := rows.Scan(&row.oui, &prefix, &registry, &row.assignment, &row.organization); err != nil {
+			rows.Close()
+			return false, fmt.Errorf("error reading SQLite database: %w", err)
+		}
+
+		row.prefix, row.registry = prefix.String, registry.String
+		changes[row]--
+	}
+
+	if err := rows.Err(); err != nil {
+		return false, fmt.Errorf("error reading SQLite database: %w", err)
+	}
+
+	for _, entry := range entries {
+		changes[newSQLiteRow(entry)]++
+	}
+
+	changed := 0
+	for _, n := range changes {
+		changed += max(n, -n)
+	}
+
+	if changed > *sqliteDeltaMax {
+		return false, nil
+	}
+
+	remove, err := tx.Prepare(`DELETE FROM ouis WHERE rowid IN (
+		SELECT rowid FROM ouis
+		WHERE oui = ? AND prefix IS ? AND registry IS ? AND assignment = ? AND organization_name = ?
+		LIMIT ?
+	)`)
+	if err != nil {
+		return false, fmt.Errorf("error preparing SQLite delete: %w", err)
+	}
+	defer remove.Close()
+
+	insert, err := tx.Prepare("INSERT INTO ouis (oui, prefix, registry, assignment, organization_name) VALUES (?, ?, ?, ?, ?)")
+	if err != nil {
+		return false, fmt.Errorf("error preparing SQLite insert: %w", err)
+	}
+	defer insert.Close()
+
+	for row, n := range changes {
+		values := []any{
+			row.oui,
+			sql.NullString{String: row.prefix, Valid: row.prefix != ""},
+			sql.NullString{String: row.registry, Valid: row.registry != ""},
+			row.assignment,
+			row.organization,
+		}
+
+		if n < 0 {
+			if _, err := remove.Exec(append(values, -n)...); err != nil {
+				return false, fmt.Errorf("error deleting from SQLite table: %w", err)
+			}
+		}
+
+		for range n {
+			if _, err := insert.Exec(values...); err != nil {
+				return false, fmt.Errorf("error inserting into SQLite table: %w", err)
+			}
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return false, fmt.Errorf("error committing SQLite transaction: %w", err)
+	}
+
+	slog.Debug("Updated SQLite output file in place", "changed_rows", changed)
+
+	return true, db.Close()
+}
+
+// Create a SQLite database holding the OUI database
+func buildSQLite(filename string, entries []ouiEntry) error {
+	db, err := sql.Open("sqlite", filename)
+	if err != nil {
+		return fmt.Errorf("error opening SQLite database: %w", err)
+	}
+	defer db.Close()
+
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("error starting SQLite transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(`CREATE TABLE ouis (
+		oui TEXT NOT NULL,
+		prefix TEXT,
+		registry TEXT,
+		assignment TEXT NOT NULL,
+		organization_name TEXT NOT NULL
+	)`)
+	if err != nil {
+		return fmt.Errorf("error creating SQLite table: %w", err)
+	}
+
+	insert, err := tx.Prepare("INSERT INTO ouis (oui, prefix, registry, assignment, organization_name) VALUES (?, ?, ?, ?, ?)")
+	if err != nil {
+		return fmt.Errorf("error preparing SQLite insert: %w", err)
+	}
+	defer insert.Close()
+
+	for _, entry := range entries {
+		_, err := insert.Exec(
+			entry.OUI,
+			sql.NullString{String: entry.Prefix, Valid: entry.Prefix != ""},
+			sql.NullString{String: entry.Registry, Valid: entry.Registry != ""},
+			entry.Assignment,
+			entry.Organization,
+		)
+		if err != nil {
+			return fmt.Errorf("error inserting into SQLite table: %w", err)
+		}
+	}
+
+	if _, err := tx.Exec("CREATE INDEX ouis_oui ON ouis (oui)"); err != nil {
+		return fmt.Errorf("error creating SQLite index: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("error committing SQLite transaction: %w", err)
+	}
+
+	return db.Close()
+}
+
+// Read the OUIs and their organizations back from a SQLite output file
+func readSQLiteOrgs(filename string) ([]ouidb.Org, error) {
+	// The database is opened read-only, so that a missing file isn't
+	// created
+	db, err := sql.Open("sqlite", "file:"+filename+"?mode=ro")
+	if err != nil {
+		return nil, fmt.Errorf("error opening SQLite database: %w", err)
+	}
+	defer db.Close()
+
+	rows, err := db.Query("SELECT oui, prefix, registry, organization_name FROM ouis ORDER BY rowid")
+	if err != nil {
+		return nil, fmt.Errorf("error querying SQLite database: %w", err)
+	}
+	defer rows.Close()
+
+	orgs := []ouidb.Org{}
+
+	for rows.Next() {
+		var oui, name string
+		var prefix, registry sql.NullString
+
+		if err := rows.Scan(&oui, &prefix, &registry, &name); err != nil {
+			return nil, fmt.Errorf("error reading SQLite database: %w", err)
+		}
+
+		org := ouidb.Org{Name: name, Registry: registry.String, Prefix: prefix.String}
+		if org.Prefix == "" {
+			org.Prefix = oui
+		}
+
+		orgs = append(orgs, org)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error reading SQLite database: %w", err)
+	}
+
+	return orgs, nil
+}
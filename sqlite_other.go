@@ -0,0 +1,19 @@
+//go:build !((darwin && (amd64 || arm64)) || (freebsd && (386 || amd64 || arm || arm64)) || (linux && (386 || amd64 || arm || arm64)) || (netbsd && amd64) || (openbsd && (amd64 || arm64)))
+
+package main
+
+import (
+	"errors"
+
+	"github.com/adaricorp/oui-textfile-collector/pkg/ouidb"
+)
+
+// The SQLite driver, which is a translation of SQLite to Go, only supports
+// some platforms
+func writeSQLite(filename string, records []ouiRecord) error {
+	return withClass("write", errors.New("SQLite output is not supported on this platform"))
+}
+
+func readSQLiteOrgs(filename string) ([]ouidb.Org, error) {
+	return nil, errors.New("SQLite output is not supported on this platform")
+}
@@ -0,0 +1,308 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"os"
+	"slices"
+	"time"
+
+	dto "github.com/prometheus/client_model/go"
+)
+
+// Summary of a successful refresh of the OUI database
+type refreshSummary struct {
+	Timestamp       time.Time `json:"timestamp"`
+	DurationSeconds float64   `json:"duration_seconds"`
+	Entries         int       `json:"entries"`
+	PreviousEntries int       `json:"previous_entries"`
+	Added           []string  `json:"added"`
+	Removed         []string  `json:"removed"`
+	Renamed         []string  `json:"renamed"`
+	SourceSHA256    string    `json:"source_sha256"`
+}
+
+// OUIs which changed between two refreshes
+type ouiChanges struct {
+	added   []string
+	removed []string
+	renamed []string
+}
+
+// Compare the new OUIs against the previous ones
+func diffOUIs(previous map[string]string, current map[string]string) ouiChanges {
+	changes := ouiChanges{
+		added:   []string{},
+		removed: []string{},
+		renamed: []string{},
+	}
+
+	// Every OUI would be reported as added on the first refresh
+	if previous == nil {
+		return changes
+	}
+
+	for oui, organization := range current {
+		cur, exists := previous[oui]
+		switch {
+		case !exists:
+			changes.added = append(changes.added, oui)
+		case cur != organization:
+			changes.renamed = append(changes.renamed, oui)
+		}
+	}
+
+	for oui := range previous {
+		if _, exists := current[oui]; !exists {
+			changes.removed = append(changes.removed, oui)
+		}
+	}
+
+	slices.Sort(changes.added)
+	slices.Sort(changes.removed)
+	slices.Sort(changes.renamed)
+
+	return changes
+}
+
+// Numbers of OUIs added to and removed from the OUI database between
+// refreshes since startup
+var (
+	addedTotal   int
+	removedTotal int
+)
+
+// Count the OUIs added and removed since the previous refresh. The counts are
+// only added to the totals once the refresh is published.
+func entryChangeSamples(changes ouiChanges) []sample {
+	return []sample{
+		{
+			name:  metricNameWithSuffix("entries_added_total"),
+			value: float64(addedTotal + len(changes.added)),
+		},
+		{
+			name:  metricNameWithSuffix("entries_removed_total"),
+			value: float64(removedTotal + len(changes.removed)),
+		},
+	}
+}
+
+// Add the changes of a published refresh to the totals, appending the renamed
+// OUIs to the changelog and reporting the changes to watched OUIs
+func recordChanges(changes ouiChanges, watched []watchedChange, previous map[string]string, current map[string]string) {
+	addedTotal += len(changes.added)
+	removedTotal += len(changes.removed)
+	renamedTotal += len(changes.renamed)
+
+	if *renameLogFile != "" && len(changes.renamed) > 0 {
+		if err := appendRenameLog(*renameLogFile, changes.renamed, previous, current); err != nil {
+			slog.Error("Error logging renamed OUIs", "error", err.Error())
+		}
+	}
+
+	recordWatchedChanges(watched)
+}
+
+// Read the value of a counter from a parsed metric file, or 0 if it isn't
+// there. In OpenMetrics, the TYPE line names the family without the _total
+// suffix, so the samples are parsed as untyped.
+func counterValue(families map[string]*dto.MetricFamily, name string, match func(labels map[string]string) bool) float64 {
+	for _, m := range families[name].GetMetric() {
+		if match != nil && !match(labelValues(m)) {
+			continue
+		}
+
+		if m.GetCounter() != nil {
+			return m.GetCounter().GetValue()
+		}
+
+		return m.GetUntyped().GetValue()
+	}
+
+	return 0
+}
+
+// Restore the OUIs and the change totals of the previous run from the metric
+// file, so that the changes found by the first refresh after a restart or by
+// a --oneshot run are logged and counted. Without a metric file, or if it
+// doesn't hold every OUI because they are filtered, there is nothing to
+// compare with.
+func loadPreviousRefresh(metricFile string) (map[string]string, error) {
+	if metricFile == "" || *outputFormat != "prom" || isFIFO(metricFile) || filtersEnabled() || seenOnly() {
+		return nil, nil
+	}
+
+	if _, err := os.Stat(metricFile); errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+
+	families, err := parseMetricFile(metricFile)
+	if err != nil {
+		return nil, err
+	}
+
+	orgs, err := metricOrgs(families)
+	if err != nil {
+		return nil, err
+	}
+
+	// The metric file of a failed first refresh has no OUIs
+	if len(orgs) == 0 {
+		return nil, nil
+	}
+
+	previous := map[string]string{}
+
+	for _, org := range orgs {
+		// The records of an OUI are written one after the other with the
+		// records duplicate policy
+		if organization, ok := previous[org.Prefix]; ok {
+			previous[org.Prefix] = organization + *joinDelimiter + org.Name
+		} else {
+			previous[org.Prefix] = org.Name
+		}
+	}
+
+	addedTotal = int(counterValue(families, metricNameWithSuffix("entries_added_total"), nil))
+	removedTotal = int(counterValue(families, metricNameWithSuffix("entries_removed_total"), nil))
+	renamedTotal = int(counterValue(families, metricNameWithSuffix("renamed_total"), nil))
+
+	for change := range watchlistChangesTotal {
+		watchlistChangesTotal[change] = int(counterValue(
+			families,
+			metricNameWithSuffix("watchlist_changes_total"),
+			func(labels map[string]string) bool { return labels["change"] == change },
+		))
+	}
+
+	return previous, nil
+}
+
+// Log the OUIs added, removed and renamed since the previous refresh
+func logChanges(changes ouiChanges, previous map[string]string, current map[string]string) {
+	// There is nothing to compare with on the first refresh
+	if previous == nil {
+		return
+	}
+
+	for _, oui := range changes.added {
+		slog.Info("OUI added", "oui", oui, "organization", current[oui])
+	}
+
+	for _, oui := range changes.removed {
+		slog.Info("OUI removed", "oui", oui, "organization", previous[oui])
+	}
+
+	for _, oui := range changes.renamed {
+		slog.Info("OUI renamed", "oui", oui, "previous_organization", previous[oui], "organization", current[oui])
+	}
+
+	slog.Info(
+		"OUI database changes since previous refresh",
+		"added",
+		len(changes.added),
+		"removed",
+		len(changes.removed),
+		"renamed",
+		len(changes.renamed),
+	)
+}
+
+// Summarize a refresh of the OUI database
+func newRefreshSummary(
+	changes ouiChanges,
+	previous map[string]string,
+	current map[string]string,
+	dl download,
+	duration time.Duration,
+) refreshSummary {
+	return refreshSummary{
+		Timestamp:       time.Now(),
+		DurationSeconds: duration.Seconds(),
+		Entries:         len(current),
+		PreviousEntries: len(previous),
+		Added:           changes.added,
+		Removed:         changes.removed,
+		Renamed:         changes.renamed,
+		SourceSHA256:    dl.sha256,
+	}
+}
+
+// Machine-readable summary of a refresh attempt, successful or not
+type runSummary struct {
+	Timestamp       time.Time `json:"timestamp"`
+	DurationSeconds float64   `json:"duration_seconds"`
+	Success         bool      `json:"success"`
+	Entries         int       `json:"entries"`
+	PreviousEntries int       `json:"previous_entries"`
+	Added           int       `json:"added"`
+	Removed         int       `json:"removed"`
+	Renamed         int       `json:"renamed"`
+	SourceSHA256    string    `json:"source_sha256,omitempty"`
+	ResponseBytes   int64     `json:"response_bytes"`
+	Error           string    `json:"error,omitempty"`
+	ErrorClass      string    `json:"error_class,omitempty"`
+}
+
+// Build the run summary of a refresh attempt. The changes are only known for
+// successful refreshes.
+func newRunSummary(
+	changes ouiChanges,
+	previous map[string]string,
+	current map[string]string,
+	dl download,
+	duration time.Duration,
+	err error,
+	errClass string,
+) runSummary {
+	summary := runSummary{
+		Timestamp:       time.Now(),
+		DurationSeconds: duration.Seconds(),
+		Success:         err == nil,
+		Entries:         len(current),
+		PreviousEntries: len(previous),
+		Added:           len(changes.added),
+		Removed:         len(changes.removed),
+		Renamed:         len(changes.renamed),
+		SourceSHA256:    dl.sha256,
+		ResponseBytes:   dl.fetch.responseBytes,
+	}
+
+	if err != nil {
+		summary.Entries = len(previous)
+		summary.Error = err.Error()
+		summary.ErrorClass = errClass
+	}
+
+	return summary
+}
+
+// Write a run summary as JSON to a file, or to stdout if the filename is "-"
+func writeRunSummary(filename string, summary runSummary) error {
+	if filename == "-" {
+		if err := json.NewEncoder(os.Stdout).Encode(summary); err != nil {
+			return fmt.Errorf("error writing run summary: %w", err)
+		}
+
+		return nil
+	}
+
+	return writeFileAtomic(filename, func(w *bufio.Writer) error {
+		return json.NewEncoder(w).Encode(summary)
+	})
+}
+
+// Write the run summary of a refresh attempt, if enabled
+func reportRunSummary(summary runSummary) {
+	if *summaryFile == "" {
+		return
+	}
+
+	if err := writeRunSummary(*summaryFile, summary); err != nil {
+		slog.Error("Error writing run summary", "error", err.Error())
+	}
+}
@@ -0,0 +1,215 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strconv"
+	"strings"
+	"text/template"
+
+	"github.com/prometheus/common/expfmt"
+	"github.com/prometheus/common/model"
+)
+
+// The fields available to --metric-template. Label values are escaped for use
+// between double quotes.
+type metricTemplateData struct {
+	Name         string
+	OUI          string
+	Organization string
+	// All labels of the series, by their default names
+	Labels map[string]string
+	Value  string
+}
+
+// Parse the template of the info series lines, or nil if none is set
+func parseMetricTemplate() (*template.Template, error) {
+	if *metricTemplate == "" {
+		return nil, nil
+	}
+
+	return template.New("metric").Option("missingkey=zero").Parse(*metricTemplate)
+}
+
+// Values of the OUI and organization labels of the sample which is formatted
+// to find where a template puts them
+const (
+	probeOUI          = "probe-oui"
+	probeOrganization = "probe-organization"
+)
+
+// Parse a single sample line of the text exposition format
+func parseSampleLine(line string) (sample, error) {
+	scheme := model.LegacyValidation
+	if *nameEscaping == "utf8" {
+		scheme = model.UTF8Validation
+	}
+
+	parser := expfmt.NewTextParser(scheme)
+
+	families, err := parser.TextToMetricFamilies(strings.NewReader(line + "\n"))
+	if err != nil {
+		return sample{}, err
+	}
+
+	if len(families) != 1 {
+		return sample{}, fmt.Errorf("expected one sample, got %d metrics", len(families))
+	}
+
+	for name, family := range families {
+		if len(family.GetMetric()) != 1 {
+			return sample{}, fmt.Errorf("expected one sample, got %d", len(family.GetMetric()))
+		}
+
+		m := family.GetMetric()[0]
+		s := sample{name: name, value: m.GetUntyped().GetValue()}
+
+		for _, l := range m.GetLabel() {
+			s.labels = append(s.labels, label{name: l.GetName(), value: l.GetValue()})
+		}
+
+		return s, nil
+	}
+
+	return sample{}, nil
+}
+
+// Format an info sample with a template. The output is parsed back into a
+// sample, so that it gets the constant labels and keeps the labels telling
+// the blocks of the other registries and duplicate records apart.
+func formatSampleTemplate(tmpl *template.Template, s sample) (sample, error) {
+	data := metricTemplateData{
+		Name:   s.name,
+		Labels: map[string]string{},
+		Value:  strconv.FormatFloat(s.value, 'f', -1, 64),
+	}
+
+	for _, l := range s.labels {
+		data.Labels[l.name] = escapeQuoted(l.value)
+	}
+
+	data.OUI = data.Labels["oui"]
+	data.Organization = data.Labels[organizationLabel("").name]
+
+	var b bytes.Buffer
+	if err := tmpl.Execute(&b, data); err != nil {
+		return sample{}, err
+	}
+
+	if strings.Contains(b.String(), "\n") {
+		return sample{}, fmt.Errorf("template output %q spans more than one line", b.String())
+	}
+
+	formatted, err := parseSampleLine(b.String())
+	if err != nil {
+		return sample{}, fmt.Errorf("template output %q is not a sample: %w", b.String(), err)
+	}
+
+	for _, l := range s.labels {
+		switch l.name {
+		case "registry", "prefix", "index":
+			if !slices.ContainsFunc(formatted.labels, func(own label) bool { return own.name == l.name }) {
+				formatted.labels = append(formatted.labels, l)
+			}
+		}
+	}
+
+	formatted.outputNames = true
+
+	return formatted, nil
+}
+
+// Format the sample which shows where a template puts the OUI and the
+// organization
+func formatProbeSample(tmpl *template.Template) (sample, error) {
+	return formatSampleTemplate(tmpl, sample{
+		name:   *metricName,
+		labels: []label{{name: "oui", value: probeOUI}, organizationLabel(probeOrganization)},
+		value:  1,
+	})
+}
+
+// Check that --metric-template parses and formats a sample
+func validateMetricTemplate() error {
+	tmpl, err := parseMetricTemplate()
+	if err != nil {
+		return fmt.Errorf("invalid --metric-template: %w", err)
+	}
+
+	if tmpl == nil {
+		return nil
+	}
+
+	if _, err := formatProbeSample(tmpl); err != nil {
+		return fmt.Errorf("invalid --metric-template: %w", err)
+	}
+
+	return nil
+}
+
+// Format info samples with --metric-template, if set. Samples which the
+// template fails on keep the default format.
+func applyMetricTemplate(samples []sample) []sample {
+	tmpl, err := parseMetricTemplate()
+	if err != nil || tmpl == nil {
+		return samples
+	}
+
+	for i, s := range samples {
+		formatted, err := formatSampleTemplate(tmpl, s)
+		if err != nil {
+			slog.Error("Error formatting sample with metric template", "error", err.Error())
+			continue
+		}
+
+		formatted.perOUI = s.perOUI
+		samples[i] = formatted
+	}
+
+	return samples
+}
+
+// The names of the OUI metric and of its OUI and organization labels, as they
+// are written to the metric file
+type infoSeriesNames struct {
+	metric       string
+	oui          string
+	organization string
+}
+
+// Find the names of the OUI metric and its labels in the metric file, which
+// --metric-template can change. The organization label holds hashes if
+// organization names are hashed.
+func infoNames() (infoSeriesNames, error) {
+	organization := organizationLabel("").name
+	if organization == "organization_name" {
+		organization = *orgLabel
+	}
+
+	names := infoSeriesNames{metric: *metricName, oui: *ouiLabel, organization: organization}
+
+	tmpl, err := parseMetricTemplate()
+	if err != nil || tmpl == nil {
+		return names, err
+	}
+
+	probe, err := formatProbeSample(tmpl)
+	if err != nil {
+		return names, fmt.Errorf("invalid --metric-template: %w", err)
+	}
+
+	names = infoSeriesNames{metric: probe.name}
+
+	for _, l := range probe.labels {
+		switch l.value {
+		case probeOUI:
+			names.oui = l.name
+		case organizationLabel(probeOrganization).value:
+			names.organization = l.name
+		}
+	}
+
+	return names, nil
+}
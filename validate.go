@@ -0,0 +1,482 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	neturl "net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/peterbourgon/ff/v4"
+	"github.com/prometheus/common/model"
+)
+
+// A ClickHouse table name, optionally prefixed by a database name
+var clickhouseTablePattern = regexp.MustCompile(`^([A-Za-z_][A-Za-z0-9_]*\.)?[A-Za-z_][A-Za-z0-9_]*$`)
+
+// Check that a flag holds a metric name which Prometheus will accept
+func validateMetricName(flag string, name string) error {
+	if *nameEscaping == "utf8" {
+		if !model.UTF8Validation.IsValidMetricName(name) {
+			return fmt.Errorf("invalid --%s %q: metric names must be non-empty valid UTF-8", flag, name)
+		}
+
+		return nil
+	}
+
+	if !model.LegacyValidation.IsValidMetricName(name) {
+		return fmt.Errorf(
+			"invalid --%s %q: metric names must start with a letter, '_' or ':' followed by letters, digits, '_' or ':' (try %q, or --name-escaping utf8)",
+			flag,
+			name,
+			model.EscapeName(name, model.UnderscoreEscaping),
+		)
+	}
+
+	return nil
+}
+
+// Check the parsed flags for values which would only fail much later, e.g.
+// when node_exporter rejects the generated metric file. All problems found
+// are returned.
+func validateFlags() error {
+	errs := []error{}
+
+	if _, err := time.ParseDuration(*refreshInterval); err != nil {
+		errs = append(errs, fmt.Errorf("invalid --refresh-interval %q: %w", *refreshInterval, err))
+	}
+
+	if *refreshSchedule != "" {
+		schedule, err := parseCronSchedule(*refreshSchedule)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("invalid --refresh-schedule %q: %w", *refreshSchedule, err))
+		} else if schedule.next(time.Now()).IsZero() {
+			errs = append(errs, fmt.Errorf("invalid --refresh-schedule %q: never matches", *refreshSchedule))
+		}
+	}
+
+	if *oneshot {
+		daemons := []struct {
+			flag  string
+			value string
+		}{
+			{"exporter-listen", *exporterListen},
+			{"capture-interface", *captureInterface},
+			{"sflow-listen", *sflowListen},
+		}
+
+		for _, d := range daemons {
+			if d.value != "" {
+				errs = append(errs, fmt.Errorf("invalid --%s: can't be used with --oneshot, which exits after a single refresh", d.flag))
+			}
+		}
+	}
+
+	if *metricFile == "" && *exporterListen == "" && *pushgatewayURL == "" {
+		errs = append(errs, fmt.Errorf("invalid --output-file: must be set unless --exporter-listen or --pushgateway-url is set"))
+	}
+
+	if *pushgatewayURL != "" {
+		if err := validateURL("pushgateway-url", *pushgatewayURL); err != nil {
+			errs = append(errs, err)
+		}
+
+		if *pushgatewayJob == "" {
+			errs = append(errs, fmt.Errorf("invalid --pushgateway-job: must not be empty"))
+		}
+
+		if *openMetrics {
+			errs = append(errs, fmt.Errorf("invalid --pushgateway-url: the Pushgateway doesn't accept OpenMetrics, unset --openmetrics"))
+		}
+	}
+
+	for _, u := range *ouiURLs {
+		if _, ok := localPath(u); ok {
+			continue
+		}
+
+		if err := validateURL("oui-url", u); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	if *sourceFile != "" && len(*ouiURLs) > 0 {
+		errs = append(errs, fmt.Errorf("invalid --source-file: can't be used with --oui-url"))
+	}
+
+	if *httpProxy != "" {
+		if err := validateURL("http-proxy", *httpProxy); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	if mode, err := strconv.ParseUint(*outputMode, 8, 32); err != nil || mode > 0o777 {
+		errs = append(errs, fmt.Errorf("invalid --output-mode %q: must be octal permissions such as 0644", *outputMode))
+	}
+
+	if mode, err := strconv.ParseUint(*exporterMode, 8, 32); err != nil || mode > 0o777 {
+		errs = append(errs, fmt.Errorf("invalid --exporter-socket-mode %q: must be octal permissions such as 0660", *exporterMode))
+	}
+
+	if path, ok := strings.CutPrefix(*exporterListen, "unix:"); ok && path == "" {
+		errs = append(errs, errors.New("invalid --exporter-listen: unix: needs the path of a socket"))
+	}
+
+	if _, _, err := outputOwnership(); err != nil {
+		errs = append(errs, err)
+	}
+
+	if (*tlsCertFile == "") != (*tlsKeyFile == "") {
+		errs = append(errs, fmt.Errorf("invalid --tls-cert-file and --tls-key-file: must be set together"))
+	}
+
+	if *outputFormat != "prom" {
+		if len(*extraOutputs) > 0 {
+			errs = append(errs, fmt.Errorf("invalid --extra-output-file: only supported with --output-format prom"))
+		}
+
+		if *mergeDir != "" {
+			errs = append(errs, fmt.Errorf("invalid --merge-dir: only supported with --output-format prom"))
+		}
+	}
+
+	if err := validateRegistries(); err != nil {
+		errs = append(errs, err)
+	}
+
+	for _, r := range []struct {
+		flag  string
+		regex string
+	}{
+		{"include-org-regex", *includeOrgRegex},
+		{"exclude-org-regex", *excludeOrgRegex},
+	} {
+		if _, err := regexp.Compile(r.regex); err != nil {
+			errs = append(errs, fmt.Errorf("invalid --%s %q: %w", r.flag, r.regex, err))
+		}
+	}
+
+	for _, s := range *includeOUIs {
+		if _, ok := normalizeOUI(s); !ok {
+			errs = append(errs, fmt.Errorf("invalid --include-oui %q: must be 6 hex digits, e.g. 00:00:0c", s))
+		}
+	}
+
+	errs = append(errs, validateLabels()...)
+
+	if err := validateMetricTemplate(); err != nil {
+		errs = append(errs, err)
+	}
+
+	if *openMetrics && *nameEscaping == "utf8" {
+		errs = append(errs, fmt.Errorf("invalid --name-escaping utf8: OpenMetrics doesn't support quoted names, unset --openmetrics"))
+	}
+
+	if *httpConcurrency < 1 {
+		errs = append(errs, fmt.Errorf("invalid --http-concurrency %d: must be at least 1", *httpConcurrency))
+	}
+
+	if *httpTimeout <= 0 {
+		errs = append(errs, fmt.Errorf("invalid --http-timeout %s: must be positive", *httpTimeout))
+	}
+
+	if *maxRetries < 0 {
+		errs = append(errs, fmt.Errorf("invalid --max-retries %d: must not be negative", *maxRetries))
+	}
+
+	if *backoffBase <= 0 {
+		errs = append(errs, fmt.Errorf("invalid --backoff-base %s: must be positive", *backoffBase))
+	}
+
+	if *backoffMax < *backoffBase {
+		errs = append(errs, fmt.Errorf("invalid --backoff-max %s: must not be shorter than --backoff-base", *backoffMax))
+	}
+
+	if *sqliteDeltaMax < 0 {
+		errs = append(errs, fmt.Errorf("invalid --sqlite-delta-max %d: must not be negative", *sqliteDeltaMax))
+	}
+
+	if *maxRedirects < 0 {
+		errs = append(errs, fmt.Errorf("invalid --max-redirects %d: must not be negative", *maxRedirects))
+	}
+
+	if *timeScale <= 0 {
+		errs = append(errs, fmt.Errorf("invalid --time-scale %g: must be greater than 0", *timeScale))
+	}
+
+	if *joinDelimiter == "" {
+		errs = append(errs, fmt.Errorf("invalid --join-delimiter: must not be empty"))
+	}
+
+	if _, err := compilePatterns(*aclOrganizations); err != nil {
+		errs = append(errs, fmt.Errorf("invalid --acl-organization: %w", err))
+	}
+
+	if *aclFile != "" && len(*aclOrganizations) == 0 {
+		errs = append(errs, fmt.Errorf("--acl-file requires at least one --acl-organization"))
+	}
+
+	if !clickhouseTablePattern.MatchString(*clickhouseTable) {
+		errs = append(errs, fmt.Errorf("invalid --clickhouse-table %q: must be a table name, optionally prefixed by a database name", *clickhouseTable))
+	}
+
+	if *recordDir != "" && *replayDir != "" {
+		errs = append(errs, fmt.Errorf("--record-dir and --replay-dir can't be used together"))
+	}
+
+	metricNames := []struct {
+		flag string
+		name string
+	}{
+		{"metric-name", *metricName},
+		{"neighbor-metric-name", *neighborMetricName},
+		{"dhcp-metric-name", *dhcpMetricName},
+		{"capture-metric-name", *captureMetricName},
+		{"snmp-metric-name", *snmpMetricName},
+		{"wifi-metric-name", *wifiMetricName},
+		{"sflow-metric-prefix", *sflowMetricPrefix},
+		{"nic-metric-name", *nicMetricName},
+		{"ethertype-metric-name", *ethertypeMetricName},
+		{"bluetooth-metric-name", *bluetoothMetricName},
+		{"usb-metric-name", *usbMetricName},
+		{"usb-product-metric-name", *usbProductMetric},
+		{"pci-metric-name", *pciMetricName},
+		{"enterprise-metric-name", *enterpriseMetricName},
+	}
+
+	for _, m := range metricNames {
+		if err := validateMetricName(m.flag, m.name); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// Check that the directory of a file which will be written exists and is
+// writable, without writing anything
+func validateWritable(flag string, filename string) error {
+	dir := filepath.Dir(filename)
+
+	info, err := os.Stat(dir)
+	if err != nil {
+		return fmt.Errorf("invalid --%s %q: %w", flag, filename, err)
+	}
+
+	if !info.IsDir() {
+		return fmt.Errorf("invalid --%s %q: %s is not a directory", flag, filename, dir)
+	}
+
+	if err := checkWritable(dir); err != nil {
+		return fmt.Errorf("invalid --%s %q: directory %s is not writable: %w", flag, filename, dir, err)
+	}
+
+	return nil
+}
+
+// Check that a file which will be read exists and is readable
+func validateReadable(flag string, filename string) error {
+	if err := checkReadable(filename); err != nil {
+		return fmt.Errorf("invalid --%s %q: %w", flag, filename, err)
+	}
+
+	return nil
+}
+
+// Check that a flag holds an absolute http or https URL
+func validateURL(flag string, rawURL string) error {
+	u, err := neturl.Parse(rawURL)
+	if err != nil {
+		return fmt.Errorf("invalid --%s %q: %w", flag, rawURL, err)
+	}
+
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("invalid --%s %q: must be an http or https URL", flag, rawURL)
+	}
+
+	return nil
+}
+
+// Check the paths and URLs configured by the flags, without any network
+// access or writes
+func validateResources() error {
+	errs := []error{}
+
+	writable := []struct {
+		flag string
+		path string
+	}{
+		{"output-file", *metricFile},
+		{"log-file", *logFile},
+		{"organization-hash-file", *organizationHashFile},
+		{"history-file", *historyFile},
+		{"rename-log-file", *renameLogFile},
+		{"hwdb-file", *hwdbFile},
+		{"jsonl-file", *jsonlFile},
+		{"yaml-file", *yamlFile},
+		{"acl-file", *aclFile},
+		{"health-file", *healthFile},
+		{"checkmk-file", *checkmkFile},
+		{"neighbor-file", *neighborFile},
+		{"dhcp-file", *dhcpFile},
+		{"capture-file", *captureFile},
+		{"snmp-file", *snmpFile},
+		{"wifi-file", *wifiFile},
+		{"sflow-file", *sflowFile},
+		{"nic-file", *nicFile},
+		{"ethertype-file", *ethertypeFile},
+		{"bluetooth-file", *bluetoothFile},
+		{"usb-file", *usbFile},
+		{"pci-file", *pciFile},
+		{"enterprise-file", *enterpriseFile},
+		{"profile-cpu", *profileCPU},
+		{"profile-mem", *profileMem},
+	}
+
+	for _, filename := range *extraOutputs {
+		writable = append(writable, struct {
+			flag string
+			path string
+		}{"extra-output-file", filename})
+	}
+
+	if *summaryFile != "-" {
+		writable = append(writable, struct {
+			flag string
+			path string
+		}{"summary-file", *summaryFile})
+	}
+
+	for _, w := range writable {
+		if w.path == "" {
+			continue
+		}
+
+		if err := validateWritable(w.flag, w.path); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	// Directories are checked through a file inside them
+	for _, d := range []struct {
+		flag string
+		path string
+	}{
+		{"temp-dir", *tempDir},
+		{"record-dir", *recordDir},
+	} {
+		if d.path == "" {
+			continue
+		}
+
+		if err := validateWritable(d.flag, filepath.Join(d.path, "x")); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	readable := []struct {
+		flag string
+		path string
+	}{
+		{"watchlist-file", *watchlistFile},
+		{"source-file", *sourceFile},
+		{"tls-ca-file", *tlsCAFile},
+		{"tls-cert-file", *tlsCertFile},
+		{"tls-key-file", *tlsKeyFile},
+		{"alias-file", *aliasFile},
+		{"seen-only-file", *seenOnlyFile},
+		{"dhcp-leases-file", *dhcpLeasesFile},
+		{"replay-dir", *replayDir},
+		{"merge-dir", *mergeDir},
+	}
+
+	for _, r := range readable {
+		if r.path == "" {
+			continue
+		}
+
+		if err := validateReadable(r.flag, r.path); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	urls := []struct {
+		flag string
+		url  string
+	}{
+		{"webhook-url", *webhookURL},
+		{"slack-webhook-url", *slackURL},
+		{"kea-api-url", *keaAPIURL},
+		{"clickhouse-url", *clickhouseURL},
+		{"unifi-url", *unifiURL},
+		{"ethertype-url", *ethertypeURL},
+		{"bluetooth-url", *bluetoothURL},
+		{"usb-url", *usbURL},
+		{"pci-url", *pciURL},
+		{"enterprise-url", *enterpriseURL},
+		{"wireshark-url", *wiresharkURL},
+	}
+
+	for _, u := range *routerOSURLs {
+		urls = append(urls, struct {
+			flag string
+			url  string
+		}{"routeros-url", u})
+	}
+
+	for _, u := range urls {
+		if u.url == "" {
+			continue
+		}
+
+		if err := validateURL(u.flag, u.url); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// Create the validate-config subcommand
+func newValidateConfigCommand(parent *ff.FlagSet) *ff.Command {
+	fs := ff.NewFlagSet("validate-config").SetParent(parent)
+
+	return &ff.Command{
+		Name:      "validate-config",
+		Usage:     binName + " validate-config [FLAGS]",
+		ShortHelp: "Check the configuration for errors without any network access or writes",
+		Flags:     fs,
+		Exec:      validateConfig,
+	}
+}
+
+// Report all configuration errors, exiting with a non-zero status if any are
+// found
+func validateConfig(ctx context.Context, args []string) error {
+	if len(args) > 0 {
+		return fmt.Errorf("unexpected arguments: %s", strings.Join(args, " "))
+	}
+
+	err := validateJobs()
+	if supervisingJobs() {
+		// Only the jobs run, so the top-level settings are only checked
+		// as part of each job
+		err = errors.Join(err, validateJobFlags(true))
+	} else {
+		err = errors.Join(err, validateFlags(), validateResources())
+	}
+
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s\n", err)
+		os.Exit(1)
+	}
+
+	fmt.Println("Configuration is valid")
+
+	return nil
+}
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+)
+
+var (
+	// Number of changes to watched OUIs since startup, by type of change
+	watchlistChangesTotal = map[string]int{
+		"added":   0,
+		"removed": 0,
+		"renamed": 0,
+	}
+)
+
+// OUIs and organization names whose changes should be reported
+type watchlist struct {
+	ouis          map[string]bool
+	organizations []string
+}
+
+// Normalize an OUI written as aabbcc, aa-bb-cc or aa:bb:cc to aa:bb:cc
+func normalizeOUI(s string) (string, bool) {
+	s = strings.ToLower(s)
+	s = strings.NewReplacer(":", "", "-", "", ".", "").Replace(s)
+
+	if len(s) != 6 || strings.Trim(s, "0123456789abcdef") != "" {
+		return "", false
+	}
+
+	return s[0:2] + ":" + s[2:4] + ":" + s[4:6], true
+}
+
+// Load a watchlist file, which contains one OUI or organization name per
+// line. Organization names match case-insensitively anywhere in the name.
+func loadWatchlist(filename string) (watchlist, error) {
+	w := watchlist{
+		ouis: map[string]bool{},
+	}
+
+	f, err := os.Open(filename)
+	if err != nil {
+		return w, fmt.Errorf("error opening watchlist file: %w", err)
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		if oui, ok := normalizeOUI(line); ok {
+			w.ouis[oui] = true
+		} else {
+			w.organizations = append(w.organizations, strings.ToLower(line))
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return w, fmt.Errorf("error reading watchlist file: %w", err)
+	}
+
+	return w, nil
+}
+
+// Check whether an OUI or organization name is on the watchlist
+func (w watchlist) matches(oui string, organization string) bool {
+	if w.ouis[oui] {
+		return true
+	}
+
+	organization = strings.ToLower(organization)
+
+	for _, o := range w.organizations {
+		if strings.Contains(organization, o) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// A change to a watched OUI
+type watchedChange struct {
+	change               string
+	oui                  string
+	organization         string
+	previousOrganization string
+}
+
+// Find the changes to watched OUIs between two refreshes
+func findWatchedChanges(filename string, changes ouiChanges, previous map[string]string, current map[string]string) ([]watchedChange, error) {
+	w, err := loadWatchlist(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	watched := []watchedChange{}
+
+	for _, oui := range changes.added {
+		if w.matches(oui, current[oui]) {
+			watched = append(watched, watchedChange{change: "added", oui: oui, organization: current[oui]})
+		}
+	}
+
+	for _, oui := range changes.removed {
+		if w.matches(oui, previous[oui]) {
+			watched = append(watched, watchedChange{change: "removed", oui: oui, previousOrganization: previous[oui]})
+		}
+	}
+
+	for _, oui := range changes.renamed {
+		if w.matches(oui, current[oui]) || w.matches(oui, previous[oui]) {
+			watched = append(watched, watchedChange{
+				change:               "renamed",
+				oui:                  oui,
+				organization:         current[oui],
+				previousOrganization: previous[oui],
+			})
+		}
+	}
+
+	return watched, nil
+}
+
+// Build samples describing the changes to watched OUIs. The changes are only
+// added to the totals once the refresh is published.
+func watchlistSamples(watched []watchedChange) []sample {
+	samples := []sample{}
+	pending := map[string]int{}
+
+	for _, c := range watched {
+		pending[c.change]++
+
+		samples = append(samples, sample{
+			name: metricNameWithSuffix("watchlist_changed"),
+			labels: []label{
+				{name: "change", value: c.change},
+				{name: "oui", value: c.oui},
+				{name: "organization_name", value: c.organization},
+				{name: "previous_organization_name", value: c.previousOrganization},
+			},
+			value: 1,
+		})
+	}
+
+	for _, change := range []string{"added", "removed", "renamed"} {
+		samples = append(samples, sample{
+			name:   metricNameWithSuffix("watchlist_changes_total"),
+			labels: []label{{name: "change", value: change}},
+			value:  float64(watchlistChangesTotal[change] + pending[change]),
+		})
+	}
+
+	return samples
+}
+
+// Log the changes to watched OUIs of a published refresh and add them to the
+// totals
+func recordWatchedChanges(watched []watchedChange) {
+	for _, c := range watched {
+		slog.Warn(
+			"Watched OUI changed",
+			"change",
+			c.change,
+			"oui",
+			c.oui,
+			"organization_name",
+			c.organization,
+			"previous_organization_name",
+			c.previousOrganization,
+		)
+
+		watchlistChangesTotal[c.change]++
+	}
+}
@@ -0,0 +1,274 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/http/cookiejar"
+	neturl "net/url"
+	"strings"
+)
+
+// A client connected to a wireless controller or access point
+type wifiClient struct {
+	controller string
+	ap         string
+	ssid       string
+	mac        net.HardwareAddr
+}
+
+// Create an HTTP client for a session with a controller, sharing the transport
+// of the controller client but keeping its own session cookies
+func newControllerClient() *http.Client {
+	jar, _ := cookiejar.New(nil)
+
+	return &http.Client{
+		Timeout:   controllerClient.Timeout,
+		Transport: controllerClient.Transport,
+		Jar:       jar,
+	}
+}
+
+// Do a JSON HTTP request against a controller, decoding the response into v
+func controllerRequest(ctx context.Context, client *http.Client, method string, url string, payload any, v any, modify func(*http.Request)) error {
+	var body io.Reader
+	if payload != nil {
+		b, err := json.Marshal(payload)
+		if err != nil {
+			return fmt.Errorf("error encoding request: %w", err)
+		}
+
+		body = bytes.NewReader(b)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, url, body)
+	if err != nil {
+		return fmt.Errorf("error creating http request: %w", err)
+	}
+
+	req.Header.Set("Accept", "application/json")
+	req.Header.Set("User-Agent", userAgent)
+
+	if payload != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	if modify != nil {
+		modify(req)
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("error doing http request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected http status from %s: %s", req.URL.Path, resp.Status)
+	}
+
+	if v == nil {
+		return nil
+	}
+
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		return fmt.Errorf("error decoding response from %s: %w", req.URL.Path, err)
+	}
+
+	return nil
+}
+
+// Read the connected wireless clients from a UniFi network controller
+func readUniFiClients(ctx context.Context, base string) ([]wifiClient, error) {
+	client := newControllerClient()
+	base = strings.TrimSuffix(base, "/")
+
+	login, api := base+"/api/login", base+"/api"
+	if *unifiOS {
+		// UniFi OS consoles proxy the network application under a prefix
+		login, api = base+"/api/auth/login", base+"/proxy/network/api"
+	}
+
+	credentials := map[string]string{
+		"username": *unifiUsername,
+		"password": *unifiPassword,
+	}
+	if err := controllerRequest(ctx, client, http.MethodPost, login, credentials, nil, nil); err != nil {
+		return nil, fmt.Errorf("error logging in to UniFi controller: %w", err)
+	}
+
+	site := api + "/s/" + neturl.PathEscape(*unifiSite)
+
+	devices := struct {
+		Data []struct {
+			MAC  string `json:"mac"`
+			Name string `json:"name"`
+		} `json:"data"`
+	}{}
+	if err := controllerRequest(ctx, client, http.MethodGet, site+"/stat/device", nil, &devices, nil); err != nil {
+		return nil, fmt.Errorf("error listing UniFi devices: %w", err)
+	}
+
+	apNames := map[string]string{}
+	for _, d := range devices.Data {
+		apNames[d.MAC] = d.Name
+	}
+
+	stations := struct {
+		Data []struct {
+			MAC     string `json:"mac"`
+			APMAC   string `json:"ap_mac"`
+			ESSID   string `json:"essid"`
+			IsWired bool   `json:"is_wired"`
+		} `json:"data"`
+	}{}
+	if err := controllerRequest(ctx, client, http.MethodGet, site+"/stat/sta", nil, &stations, nil); err != nil {
+		return nil, fmt.Errorf("error listing UniFi clients: %w", err)
+	}
+
+	clients := []wifiClient{}
+
+	for _, s := range stations.Data {
+		mac, err := net.ParseMAC(s.MAC)
+		if err != nil || s.IsWired {
+			continue
+		}
+
+		ap := apNames[s.APMAC]
+		if ap == "" {
+			ap = s.APMAC
+		}
+
+		clients = append(clients, wifiClient{
+			controller: base,
+			ap:         ap,
+			ssid:       s.ESSID,
+			mac:        mac,
+		})
+	}
+
+	return clients, nil
+}
+
+// Read the wireless registration table from a MikroTik RouterOS v7 REST API.
+// The wifi package is tried first, falling back to the legacy wireless one.
+func readRouterOSClients(ctx context.Context, base string) ([]wifiClient, error) {
+	client := newControllerClient()
+	base = strings.TrimSuffix(base, "/")
+
+	auth := func(req *http.Request) {
+		req.SetBasicAuth(*routerOSUsername, *routerOSPassword)
+	}
+
+	host := base
+	if u, err := neturl.Parse(base); err == nil {
+		host = u.Hostname()
+	}
+
+	var err error
+
+	for _, path := range []string{"/rest/interface/wifi/registration-table", "/rest/interface/wireless/registration-table"} {
+		registrations := []struct {
+			MACAddress string `json:"mac-address"`
+			Interface  string `json:"interface"`
+			SSID       string `json:"ssid"`
+		}{}
+
+		err = controllerRequest(ctx, client, http.MethodGet, base+path, nil, &registrations, auth)
+		if err != nil {
+			continue
+		}
+
+		clients := []wifiClient{}
+
+		for _, r := range registrations {
+			mac, err := net.ParseMAC(r.MACAddress)
+			if err != nil {
+				continue
+			}
+
+			ssid := r.SSID
+			if ssid == "" {
+				ssid = r.Interface
+			}
+
+			clients = append(clients, wifiClient{
+				controller: base,
+				ap:         host,
+				ssid:       ssid,
+				mac:        mac,
+			})
+		}
+
+		return clients, nil
+	}
+
+	return nil, fmt.Errorf("error reading RouterOS registration table: %w", err)
+}
+
+// Build samples counting the wireless clients of each vendor per AP and SSID
+func wifiSamples(ouiMap map[string]string, clients []wifiClient) []sample {
+	type key struct {
+		controller, ap, ssid, organization string
+	}
+
+	counts := map[key]int{}
+	ssids := map[string][]net.HardwareAddr{}
+
+	for _, c := range clients {
+		counts[key{c.controller, c.ap, c.ssid, ouiMap[macOUI(c.mac)]}]++
+		ssids[c.ssid] = append(ssids[c.ssid], c.mac)
+	}
+
+	samples := make([]sample, 0, len(counts))
+
+	for k, count := range counts {
+		samples = append(samples, sample{
+			name: *wifiMetricName,
+			labels: []label{
+				{name: "controller", value: k.controller},
+				{name: "ap", value: k.ap},
+				{name: "ssid", value: k.ssid},
+				{name: "organization_name", value: k.organization},
+			},
+			value: float64(count),
+		})
+	}
+
+	return append(samples, randomizationSamples("wifi", "ssid", ssids)...)
+}
+
+// Poll the controllers for the wireless client metric file, if enabled and the
+// OUI database is loaded
+func pollWifi(ctx context.Context, ouiMap map[string]string) (enrichmentOutput, bool) {
+	if *wifiFile == "" || ouiMap == nil {
+		return enrichmentOutput{}, false
+	}
+
+	clients := []wifiClient{}
+
+	if *unifiURL != "" {
+		c, err := readUniFiClients(ctx, *unifiURL)
+		if err != nil {
+			slog.Error("Error reading UniFi clients", "error", err.Error())
+		}
+
+		clients = append(clients, c...)
+	}
+
+	for _, router := range *routerOSURLs {
+		c, err := readRouterOSClients(ctx, router)
+		if err != nil {
+			slog.Error("Error reading RouterOS clients", "router", router, "error", err.Error())
+		}
+
+		clients = append(clients, c...)
+	}
+
+	return enrichmentOutput{filename: *wifiFile, samples: wifiSamples(ouiMap, clients)}, true
+}
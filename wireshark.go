@@ -0,0 +1,172 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"log/slog"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/adaricorp/oui-textfile-collector/pkg/ouidb"
+)
+
+// The registries of the blocks in a Wireshark manuf file, keyed by the length
+// of their prefix in bits
+var manufRegistries = map[int]ieeeRegistry{
+	24: ieeeRegistries[0],
+	28: ieeeRegistries[1],
+	36: ieeeRegistries[2],
+}
+
+// URLs of the OUI database of the selected source, in the order in which
+// they are tried
+func sourceURLs() []string {
+	if *sourceFile != "" {
+		return []string{sourceFileURL()}
+	}
+
+	if len(*ouiURLs) > 0 {
+		return *ouiURLs
+	}
+
+	if *ouiSource == "wireshark" {
+		return []string{*wiresharkURL}
+	}
+
+	return []string{url}
+}
+
+// URL of the OUI database of the selected source, or its first mirror
+func sourceURL() string {
+	return sourceURLs()[0]
+}
+
+// Name of the temporary file which the OUI database is downloaded to
+func sourcePattern() string {
+	if *ouiSource == "wireshark" {
+		return "manuf"
+	}
+
+	return "oui.csv"
+}
+
+// Parse the downloaded OUI database of the selected source
+func parseSource(filename string) ([]ouiRecord, error) {
+	if *ouiSource == "wireshark" {
+		return parseManuf(filename)
+	}
+
+	return parse(filename, ieeeRegistries[0])
+}
+
+// Parse a Wireshark manuf file, which merges the MA-L, MA-M and MA-S
+// registries. Each line holds a prefix, followed by /bits unless it is as long
+// as its digits, a short name and the organization name, separated by tabs.
+// Older files have the organization name in a trailing comment and also list
+// well-known multicast addresses, which are skipped.
+func parseManuf(filename string) ([]ouiRecord, error) {
+	input, err := os.Open(filename)
+	if err != nil {
+		return nil, fmt.Errorf("error opening Wireshark manuf file: %w", err)
+	}
+	defer input.Close()
+
+	records := []ouiRecord{}
+	malformed := []int{}
+	scanner := bufio.NewScanner(input)
+	line := 0
+
+	for scanner.Scan() {
+		line++
+
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+
+		fields := strings.Split(text, "\t")
+		if len(fields) < 2 {
+			slog.Error("Wireshark manuf line has too few fields", "line", line)
+			malformed = append(malformed, line)
+
+			continue
+		}
+
+		prefix, bitsText, hasBits := strings.Cut(fields[0], "/")
+
+		digits := strings.ToLower(strings.NewReplacer(":", "", "-", "", ".", "").Replace(prefix))
+		if digits == "" || strings.Trim(digits, "0123456789abcdef") != "" {
+			slog.Error("Wireshark manuf line has an invalid prefix", "line", line, "prefix", fields[0])
+			malformed = append(malformed, line)
+
+			continue
+		}
+
+		// Without /bits, the prefix is as long as its digits, e.g. a full
+		// well-known address in older files
+		bits := len(digits) * 4
+		if hasBits {
+			if bits, err = strconv.Atoi(bitsText); err != nil {
+				slog.Error("Wireshark manuf line has an invalid prefix length", "line", line)
+				malformed = append(malformed, line)
+
+				continue
+			}
+		}
+
+		registry, ok := manufRegistries[bits]
+		if !ok || isGroupPrefix(digits) {
+			// Well-known addresses and other blocks which aren't IEEE
+			// assignments
+			slog.Debug("Skipping Wireshark manuf block", "prefix", fields[0])
+
+			continue
+		}
+
+		if len(digits) < registry.digits {
+			slog.Error("Wireshark manuf line has an invalid prefix", "line", line, "prefix", fields[0])
+			malformed = append(malformed, line)
+
+			continue
+		}
+
+		digits = digits[:registry.digits]
+
+		organization := strings.TrimSpace(fields[1])
+		if len(fields) > 2 && !*wiresharkShortNames {
+			organization = strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(fields[2]), "#"))
+		}
+
+		record := ouiRecord{
+			registry:     registry.name,
+			assignment:   strings.ToUpper(digits),
+			oui:          ouidb.FormatPrefix(digits[0:6]),
+			organization: organization,
+		}
+
+		if registry.digits > 6 {
+			record.prefix = ouidb.FormatPrefix(digits)
+		}
+
+		records = append(records, record)
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, withClass("parse", fmt.Errorf("error reading Wireshark manuf file: %w", err))
+	}
+
+	if err := checkMalformedRows("Wireshark manuf file", malformed); err != nil {
+		return nil, err
+	}
+
+	return records, nil
+}
+
+// Check whether a prefix has the group bit set, which the individual
+// addresses of IEEE assignments never have
+func isGroupPrefix(digits string) bool {
+	first, err := strconv.ParseUint(digits[:min(len(digits), 2)], 16, 8)
+
+	return err == nil && first&0x01 != 0
+}